@@ -15,10 +15,8 @@ package vaultstorage
 
 import (
 	"context"
-	b64 "encoding/base64"
 	"encoding/json"
-	"log"
-	"strings"
+	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -27,15 +25,21 @@ import (
 // StoreAccount stores an account.  It will fail if it cannot store the data.
 // Note this will overwrite an existing account with the same ID.  It will not, however, allow multiple accounts with the same
 // name to co-exist in the same wallet.
-func (s *Store) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+func (s *Store) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) (err error) {
+	ctx, op := s.startOperation(context.Background(), "store_account")
+	defer op.end(&err)
+	if err := s.checkWritable("store account"); err != nil {
+		return err
+	}
+
 	// Ensure the wallet exists
-	_, err := s.RetrieveWalletByID(walletID)
+	walletData, err := s.retrieveWalletByID(ctx, walletID)
 	if err != nil {
 		return errors.New("unknown wallet")
 	}
 
 	// See if an account with this name already exists
-	existingAccount, err := s.RetrieveAccount(walletID, accountID)
+	existingAccount, err := s.retrieveAccount(ctx, walletID, accountID)
 	if err == nil {
 		// It does; they need to have the same ID for us to overwrite it
 		info := &struct {
@@ -50,34 +54,46 @@ func (s *Store) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byt
 		}
 	}
 
-	data, err = s.encryptIfRequired(data)
+	secret, err := s.sealSecret(data, accountBinding(walletID, accountID))
 	if err != nil {
 		return err
 	}
 
 	path := s.accountPath(walletID, accountID)
-	sEnc := b64.URLEncoding.EncodeToString(data)
-	s.client.KVv2(s.vault_secrets_mount_path).Put(context.Background(), path, map[string]interface{}{
-		"data": sEnc,
-	})
-
+	err = s.kvPut(ctx, path, secret)
+	s.audit(ctx, AuditWrite, accountBinding(walletID, accountID), err)
 	if err != nil {
 		return errors.Wrap(err, "failed to store key")
 	}
+	if err := s.storeCustomMetadata(ctx, path, accountMetadata(walletData, accountID, data)); err != nil {
+		return err
+	}
+
+	// The public key index is best-effort; RebuildPublicKeyIndex() repairs it.
+	if err := s.indexAccountPublicKey(ctx, walletID, accountID, data); err != nil {
+		s.logger.Warn("failed to add account to public key index", secretFields(accountBinding(walletID, accountID), err)...)
+	}
 	return nil
 }
 
 // RetrieveAccount retrieves account-level data.  It will fail if it cannot retrieve the data.
 func (s *Store) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte, error) {
+	return s.retrieveAccount(context.Background(), walletID, accountID)
+}
+
+func (s *Store) retrieveAccount(ctx context.Context, walletID uuid.UUID, accountID uuid.UUID) (_ []byte, err error) {
+	ctx, op := s.startOperation(ctx, "retrieve_account")
+	defer op.end(&err)
 	path := s.accountPath(walletID, accountID)
 
-	secret, err := s.client.KVv2(s.vault_secrets_mount_path).Get(context.Background(), path)
+	binding := accountBinding(walletID, accountID)
+	secret, err := s.kvGet(ctx, path)
 	if err != nil {
+		s.audit(ctx, AuditRead, binding, err)
 		return nil, err
 	}
-	returnedData, _ := secret.Data["data"].(string)
-	sDec, _ := b64.URLEncoding.DecodeString(returnedData)
-	data, err := s.decryptIfRequired(sDec)
+	data, err := s.openSecret(secret, binding)
+	s.audit(ctx, AuditRead, binding, err)
 	if err != nil {
 		return nil, err
 	}
@@ -86,47 +102,82 @@ func (s *Store) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte
 
 // RetrieveAccounts retrieves all account-level data for a wallet.
 func (s *Store) RetrieveAccounts(walletID uuid.UUID) <-chan []byte {
-	path := s.walletPath(walletID)
+	return s.retrieveAccounts(context.Background(), walletID)
+}
+
+func (s *Store) retrieveAccounts(ctx context.Context, walletID uuid.UUID) <-chan []byte {
+	ctx, op := s.startOperation(ctx, "retrieve_accounts")
 	ch := make(chan []byte, 1024)
 	go func() {
-		endpoint := "/" + s.vault_secrets_mount_path + "/metadata/wallets/" + path
-		accountList, err := s.client.Logical().List(endpoint)
+		defer close(ch)
+		var err error
+		defer op.end(&err)
+		if err = s.sendAccounts(ctx, walletID, ch); err != nil {
+			s.logger.Error("failed to retrieve accounts", "wallet", walletID, "error", err)
+		}
+	}()
+	return ch
+}
+
+// sendAccounts sends the data of each account in the wallet to the channel.  Accounts that cannot be retrieved or
+// decrypted are skipped unless retrieval is strict, in which case the first is returned as an error.
+func (s *Store) sendAccounts(ctx context.Context, walletID uuid.UUID, ch chan<- []byte) error {
+	accountList, err := s.listAccountIDs(ctx, walletID, s.account_layout)
+	if err != nil {
+		return errors.Wrap(err, "failed to list accounts")
+	}
+
+	for _, account := range accountList {
+		uuidId, err := uuid.Parse(account)
 		if err != nil {
-			log.Fatalln(err)
+			continue
+		}
+		binding := accountBinding(walletID, uuidId)
+		secret, err := s.kvGet(ctx, s.accountPath(walletID, uuidId))
+		if err != nil {
+			s.audit(ctx, AuditRead, binding, err)
+			if s.strict_retrieval {
+				return errors.Wrap(err, fmt.Sprintf("failed to retrieve %s", binding))
+			}
+			s.logger.Warn("skipped account that could not be retrieved", secretFields(binding, err)...)
+			continue
 		}
 
-		if err == nil && accountList != nil && accountList.Data != nil {
-			k := accountList.Data["keys"].([]interface{})
-			if k != nil {
-				for _, item := range k {
-					account := item.(string)
-					if strings.HasSuffix(account, "/") {
-						// Directory
-						continue
-					}
-					if strings.HasSuffix(account, walletID.String()) {
-						// Wallet
-						continue
-					}
-
-					uuidId, _ := uuid.Parse(account)
-					secret, err := s.client.KVv2(s.vault_secrets_mount_path).Get(context.Background(), s.accountPath(walletID, uuidId))
-					if err != nil {
-						continue
-					}
-
-					returnedData, _ := secret.Data["data"].(string)
-
-					sDec, _ := b64.URLEncoding.DecodeString(returnedData)
-					data, err := s.decryptIfRequired(sDec)
-					if err != nil {
-						continue
-					}
-					ch <- data
-				}
+		data, err := s.openSecret(secret, binding)
+		s.audit(ctx, AuditRead, binding, err)
+		if err != nil {
+			if s.strict_retrieval {
+				return errors.Wrap(err, fmt.Sprintf("failed to decrypt %s", binding))
 			}
+			s.logger.Warn("skipped account that could not be decrypted", secretFields(binding, err)...)
+			continue
 		}
-		close(ch)
-	}()
-	return ch
+		ch <- data
+	}
+	return nil
+}
+
+// DeleteAccount permanently deletes an account.  It does not update the wallet's accounts index, but does try to
+// remove the account from the public key index.
+func (s *Store) DeleteAccount(walletID uuid.UUID, accountID uuid.UUID) (err error) {
+	ctx, op := s.startOperation(context.Background(), "delete_account")
+	defer op.end(&err)
+	if err := s.checkWritable("delete account"); err != nil {
+		return err
+	}
+
+	if _, err := s.retrieveAccount(ctx, walletID, accountID); err != nil {
+		return errAccountNotFound
+	}
+
+	err = s.kvDelete(ctx, s.accountPath(walletID, accountID))
+	s.audit(ctx, AuditDelete, accountBinding(walletID, accountID), err)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete account")
+	}
+
+	if err := s.unindexAccountPublicKey(ctx, walletID, accountID); err != nil {
+		s.logger.Warn("failed to remove account from public key index", secretFields(accountBinding(walletID, accountID), err)...)
+	}
+	return nil
 }
@@ -0,0 +1,430 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/wealdtech/go-ecodec"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// archiveVersion is the version of the archive format written by Export.
+const archiveVersion = 1
+
+// archiveManifestName is the name of the manifest entry in an archive.
+const archiveManifestName = "manifest.json"
+
+// archiveMaxEntrySize is the largest entry read from an archive.
+const archiveMaxEntrySize = 64 * 1024 * 1024
+
+// archiveManifestMACName is the name of the entry authenticating the manifest of an encrypted archive.
+const archiveManifestMACName = "manifest.mac"
+
+// archiveManifest describes the contents of an archive.
+type archiveManifest struct {
+	Version   int    `json:"version"`
+	Created   string `json:"created"`
+	Encrypted bool   `json:"encrypted"`
+	// Entries are the SHA-256 checksums of each entry in the archive, keyed by entry name.
+	Entries map[string]string `json:"entries"`
+}
+
+// archiveManifestMAC authenticates the manifest of an encrypted archive with a key derived from the backup passphrase.
+type archiveManifestMAC struct {
+	Salt string `json:"salt"`
+	MAC  string `json:"mac"`
+}
+
+// Export writes all wallets, accounts and accounts indices in the store to a tar archive.
+// If backupPassphrase is supplied each entry is encrypted with it, and the manifest authenticated with it; otherwise
+// entries are written as held in the store after decryption with the store passphrase.  Either way, account keys
+// remain protected by their own passphrases.
+// Export fails if any wallet, account or accounts index cannot be read or decrypted, rather than writing a partial
+// archive.
+func (s *Store) Export(w io.Writer, backupPassphrase []byte) (err error) {
+	ctx, op := s.startOperation(context.Background(), "export")
+	defer op.end(&err)
+
+	entries := make(map[string][]byte)
+	err = s.walkSecrets(ctx, func(ctx context.Context, path string, binding *secretBinding) error {
+		var name string
+		switch binding.kind {
+		case secretKindWallet:
+			name = archiveWalletName(binding.walletID)
+		case secretKindAccount:
+			name = archiveAccountName(binding.walletID, binding.accountID)
+		case secretKindIndex:
+			name = archiveIndexName(binding.walletID)
+		default:
+			// The public key index is rebuilt by the importing store.
+			return nil
+		}
+		secret, err := s.kvGet(ctx, path)
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			// No index, or removed since listing.
+			return nil
+		}
+		if err != nil {
+			s.audit(ctx, AuditRead, binding, err)
+			return errors.Wrap(err, fmt.Sprintf("failed to retrieve %s", binding))
+		}
+		data, err := s.openSecret(secret, binding)
+		s.audit(ctx, AuditRead, binding, err)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to decrypt %s", binding))
+		}
+		entries[name] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	manifest := &archiveManifest{
+		Version:   archiveVersion,
+		Created:   time.Now().UTC().Format(time.RFC3339),
+		Encrypted: len(backupPassphrase) > 0,
+		Entries:   make(map[string]string, len(entries)),
+	}
+	names := make([]string, 0, len(entries))
+	for name, data := range entries {
+		if manifest.Encrypted {
+			encrypted, err := ecodec.Encrypt(data, backupPassphrase)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("failed to encrypt %s", name))
+			}
+			entries[name] = encrypted
+		}
+		checksum := sha256.Sum256(entries[name])
+		manifest.Entries[name] = hex.EncodeToString(checksum[:])
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.Wrap(err, "failed to create manifest")
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeArchiveEntry(tw, archiveManifestName, manifestData); err != nil {
+		return err
+	}
+	if manifest.Encrypted {
+		salt := make([]byte, boundSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return errors.Wrap(err, "failed to create manifest salt")
+		}
+		macData, err := json.Marshal(&archiveManifestMAC{
+			Salt: hex.EncodeToString(salt),
+			MAC:  hex.EncodeToString(archiveMAC(manifestData, backupPassphrase, salt)),
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to create manifest MAC")
+		}
+		if err := writeArchiveEntry(tw, archiveManifestMACName, macData); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if err := writeArchiveEntry(tw, name, entries[name]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// archiveMAC returns the HMAC-SHA256 of a manifest, keyed with the backup passphrase and salt.
+func archiveMAC(manifestData []byte, backupPassphrase []byte, salt []byte) []byte {
+	key := pbkdf2.Key(backupPassphrase, salt, boundPBKDF2c, 32, sha256.New)
+	defer zero(key)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(manifestData)
+	return mac.Sum(nil)
+}
+
+// Import reads an archive written by Export and migrates its contents in to the store.
+// The backup passphrase must match that supplied to Export, and if supplied the archive must be encrypted with an
+// authenticated manifest.  Migration options, such as a dry run, are honoured.
+func (s *Store) Import(r io.Reader, backupPassphrase []byte, opts ...MigrationOption) (*MigrationReport, error) {
+	source, err := readArchive(r, backupPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	return s.Migrate(source, opts...)
+}
+
+// writeArchiveEntry writes a single entry to a tar archive.
+func writeArchiveEntry(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to write header for %s", name))
+	}
+	if _, err := tw.Write(data); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to write %s", name))
+	}
+	return nil
+}
+
+// readArchive reads and verifies an archive, returning its contents as a store.
+func readArchive(r io.Reader, backupPassphrase []byte) (*archiveStore, error) {
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read archive")
+		}
+		// Entries are bounded, as the archive is untrusted until it has been checked.
+		data, err := ioutil.ReadAll(io.LimitReader(tr, archiveMaxEntrySize+1))
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to read %s", header.Name))
+		}
+		if len(data) > archiveMaxEntrySize {
+			return nil, fmt.Errorf("archive entry %s is too large", header.Name)
+		}
+		entries[header.Name] = data
+	}
+
+	manifestData, exists := entries[archiveManifestName]
+	if !exists {
+		return nil, errors.New("archive has no manifest")
+	}
+	delete(entries, archiveManifestName)
+	macData, authenticated := entries[archiveManifestMACName]
+	delete(entries, archiveManifestMACName)
+	if len(backupPassphrase) > 0 {
+		// Authenticate the manifest before trusting anything in it.
+		if !authenticated {
+			return nil, errors.New("archive manifest is not authenticated")
+		}
+		if err := checkArchiveMAC(manifestData, macData, backupPassphrase); err != nil {
+			return nil, err
+		}
+	}
+	manifest := &archiveManifest{}
+	if err := json.Unmarshal(manifestData, manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to parse manifest")
+	}
+	if manifest.Version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+	if manifest.Encrypted && len(backupPassphrase) == 0 {
+		return nil, errors.New("archive is encrypted but no backup passphrase supplied")
+	}
+	if !manifest.Encrypted && len(backupPassphrase) > 0 {
+		return nil, errors.New("archive is not encrypted but a backup passphrase was supplied")
+	}
+
+	// Check that the entries match the manifest exactly.
+	if len(entries) != len(manifest.Entries) {
+		return nil, fmt.Errorf("archive has %d entries but manifest lists %d", len(entries), len(manifest.Entries))
+	}
+	for name, data := range entries {
+		expected, exists := manifest.Entries[name]
+		if !exists {
+			return nil, fmt.Errorf("archive entry %s not in manifest", name)
+		}
+		checksum := sha256.Sum256(data)
+		if hex.EncodeToString(checksum[:]) != expected {
+			return nil, fmt.Errorf("checksum mismatch for archive entry %s", name)
+		}
+		if manifest.Encrypted {
+			decrypted, err := ecodec.Decrypt(data, backupPassphrase)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed to decrypt archive entry %s", name))
+			}
+			entries[name] = decrypted
+		}
+	}
+
+	return newArchiveStore(entries)
+}
+
+// checkArchiveMAC checks the MAC of a manifest against the backup passphrase.
+func checkArchiveMAC(manifestData []byte, macData []byte, backupPassphrase []byte) error {
+	manifestMAC := &archiveManifestMAC{}
+	if err := json.Unmarshal(macData, manifestMAC); err != nil {
+		return errors.Wrap(err, "failed to parse manifest MAC")
+	}
+	salt, err := hex.DecodeString(manifestMAC.Salt)
+	if err != nil {
+		return errors.Wrap(err, "invalid manifest MAC salt")
+	}
+	mac, err := hex.DecodeString(manifestMAC.MAC)
+	if err != nil {
+		return errors.Wrap(err, "invalid manifest MAC")
+	}
+	if !hmac.Equal(mac, archiveMAC(manifestData, backupPassphrase, salt)) {
+		return errors.New("manifest MAC mismatch; the backup passphrase is wrong or the archive has been modified")
+	}
+	return nil
+}
+
+func archiveWalletName(walletID uuid.UUID) string {
+	return fmt.Sprintf("wallets/%s/wallet", walletID)
+}
+
+func archiveAccountName(walletID uuid.UUID, accountID uuid.UUID) string {
+	return fmt.Sprintf("wallets/%s/accounts/%s", walletID, accountID)
+}
+
+func archiveIndexName(walletID uuid.UUID) string {
+	return fmt.Sprintf("wallets/%s/index", walletID)
+}
+
+// archiveStore is a read-only store over the contents of an archive, used as the source when importing.
+type archiveStore struct {
+	wallets  map[uuid.UUID][]byte
+	accounts map[uuid.UUID]map[uuid.UUID][]byte
+	indices  map[uuid.UUID][]byte
+}
+
+// newArchiveStore creates a store from archive entries.
+func newArchiveStore(entries map[string][]byte) (*archiveStore, error) {
+	store := &archiveStore{
+		wallets:  make(map[uuid.UUID][]byte),
+		accounts: make(map[uuid.UUID]map[uuid.UUID][]byte),
+		indices:  make(map[uuid.UUID][]byte),
+	}
+	for name, data := range entries {
+		parts := strings.Split(name, "/")
+		if len(parts) < 3 || parts[0] != "wallets" {
+			return nil, fmt.Errorf("unexpected archive entry %s", name)
+		}
+		walletID, err := uuid.Parse(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected archive entry %s", name)
+		}
+		switch {
+		case len(parts) == 3 && parts[2] == "wallet":
+			store.wallets[walletID] = data
+		case len(parts) == 3 && parts[2] == "index":
+			store.indices[walletID] = data
+		case len(parts) == 4 && parts[2] == "accounts":
+			accountID, err := uuid.Parse(parts[3])
+			if err != nil {
+				return nil, fmt.Errorf("unexpected archive entry %s", name)
+			}
+			if _, exists := store.accounts[walletID]; !exists {
+				store.accounts[walletID] = make(map[uuid.UUID][]byte)
+			}
+			store.accounts[walletID][accountID] = data
+		default:
+			return nil, fmt.Errorf("unexpected archive entry %s", name)
+		}
+	}
+	return store, nil
+}
+
+// Name returns the name of this store.
+func (s *archiveStore) Name() string {
+	return "archive"
+}
+
+// StoreWallet is not supported.
+func (s *archiveStore) StoreWallet(_ uuid.UUID, _ string, _ []byte) error {
+	return errors.New("archive is read-only")
+}
+
+// RetrieveWallets retrieves wallet-level data for all wallets.
+func (s *archiveStore) RetrieveWallets() <-chan []byte {
+	ch := make(chan []byte, len(s.wallets))
+	for _, data := range s.wallets {
+		ch <- data
+	}
+	close(ch)
+	return ch
+}
+
+// RetrieveWallet retrieves wallet-level data.
+func (s *archiveStore) RetrieveWallet(walletName string) ([]byte, error) {
+	for data := range s.RetrieveWallets() {
+		info := &migrationInfo{}
+		if err := json.Unmarshal(data, info); err == nil && info.Name == walletName {
+			return data, nil
+		}
+	}
+	return nil, errWalletNotFound
+}
+
+// RetrieveWalletByID retrieves wallet-level data.
+func (s *archiveStore) RetrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
+	data, exists := s.wallets[walletID]
+	if !exists {
+		return nil, errWalletNotFound
+	}
+	return data, nil
+}
+
+// StoreAccount is not supported.
+func (s *archiveStore) StoreAccount(_ uuid.UUID, _ uuid.UUID, _ []byte) error {
+	return errors.New("archive is read-only")
+}
+
+// RetrieveAccounts retrieves all account-level data for a wallet.
+func (s *archiveStore) RetrieveAccounts(walletID uuid.UUID) <-chan []byte {
+	ch := make(chan []byte, len(s.accounts[walletID]))
+	for _, data := range s.accounts[walletID] {
+		ch <- data
+	}
+	close(ch)
+	return ch
+}
+
+// RetrieveAccount retrieves account-level data.
+func (s *archiveStore) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte, error) {
+	data, exists := s.accounts[walletID][accountID]
+	if !exists {
+		return nil, errAccountNotFound
+	}
+	return data, nil
+}
+
+// StoreAccountsIndex is not supported.
+func (s *archiveStore) StoreAccountsIndex(_ uuid.UUID, _ []byte) error {
+	return errors.New("archive is read-only")
+}
+
+// RetrieveAccountsIndex retrieves the account index.
+func (s *archiveStore) RetrieveAccountsIndex(walletID uuid.UUID) ([]byte, error) {
+	data, exists := s.indices[walletID]
+	if !exists {
+		return nil, errors.New("index not found")
+	}
+	return data, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/uuid"
+	vaultapi "github.com/hashicorp/vault/api"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	source := newFreshMountStore(t)
+	walletID := uuid.New()
+	walletData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"archive wallet"}`, walletID))
+	require.Nil(t, source.StoreWallet(walletID, "archive wallet", walletData))
+	accounts := make(map[uuid.UUID][]byte)
+	for i := 0; i < 2; i++ {
+		accountID := uuid.New()
+		accounts[accountID] = []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d"}`, accountID, i))
+		require.Nil(t, source.StoreAccount(walletID, accountID, accounts[accountID]))
+	}
+	indexData := []byte(`{"index":"test index data"}`)
+	require.Nil(t, source.StoreAccountsIndex(walletID, indexData))
+
+	tests := []struct {
+		name       string
+		passphrase []byte
+	}{
+		{
+			name: "Plain",
+		},
+		{
+			name:       "Encrypted",
+			passphrase: []byte("backup"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := new(bytes.Buffer)
+			require.Nil(t, source.Export(archive, test.passphrase))
+
+			dest := newFreshMountStore(t)
+			report, err := dest.Import(bytes.NewReader(archive.Bytes()), test.passphrase)
+			require.Nil(t, err)
+			assert.Equal(t, &vault.MigrationReport{WalletsMigrated: 1, AccountsMigrated: 2, IndicesMigrated: 1}, report)
+
+			data, err := dest.RetrieveWalletByID(walletID)
+			require.Nil(t, err)
+			assert.Equal(t, walletData, data)
+			for accountID, accountData := range accounts {
+				data, err := dest.RetrieveAccount(walletID, accountID)
+				require.Nil(t, err)
+				assert.Equal(t, accountData, data)
+			}
+			data, err = dest.RetrieveAccountsIndex(walletID)
+			require.Nil(t, err)
+			assert.Equal(t, indexData, data)
+		})
+	}
+}
+
+func TestImportBadArchive(t *testing.T) {
+	source := newFreshMountStore(t)
+	walletID := uuid.New()
+	require.Nil(t, source.StoreWallet(walletID, "archive wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"archive wallet"}`, walletID))))
+
+	archive := new(bytes.Buffer)
+	require.Nil(t, source.Export(archive, []byte("backup")))
+
+	dest := newFreshMountStore(t)
+
+	// Missing passphrase.
+	_, err := dest.Import(bytes.NewReader(archive.Bytes()), nil)
+	require.NotNil(t, err)
+	assert.Equal(t, "archive is encrypted but no backup passphrase supplied", err.Error())
+
+	// Incorrect passphrase.
+	_, err = dest.Import(bytes.NewReader(archive.Bytes()), []byte("wrong"))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "manifest MAC mismatch")
+
+	// Modified manifest.
+	modified := rewriteArchive(t, archive.Bytes(), func(name string, entry []byte) []byte {
+		if name == "manifest.json" {
+			return bytes.Replace(entry, []byte(`"encrypted":true`), []byte(`"encrypted":false`), 1)
+		}
+		return entry
+	})
+	_, err = dest.Import(bytes.NewReader(modified), []byte("backup"))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "manifest MAC mismatch")
+
+	// Missing manifest MAC.
+	stripped := rewriteArchive(t, archive.Bytes(), func(name string, entry []byte) []byte {
+		if name == "manifest.mac" {
+			return nil
+		}
+		return entry
+	})
+	_, err = dest.Import(bytes.NewReader(stripped), []byte("backup"))
+	require.NotNil(t, err)
+	assert.Equal(t, "archive manifest is not authenticated", err.Error())
+
+	// Corrupted entry.
+	_, err = dest.Import(bytes.NewReader(corruptArchive(t, archive.Bytes())), []byte("backup"))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch for archive entry")
+
+	// Not an archive.
+	_, err = dest.Import(bytes.NewReader([]byte("not an archive")), nil)
+	require.NotNil(t, err)
+}
+
+func TestExportUnreadable(t *testing.T) {
+	ctx := context.Background()
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath(mount),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "archive wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"archive wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"archive account"}`, accountID))))
+
+	// Copy the account to another location, where it cannot be decrypted.
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken("golang-test")
+	kv := client.KVv1(mount)
+	secret, err := kv.Get(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID))
+	require.Nil(t, err)
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, uuid.New()), secret.Data))
+
+	err = store.(*vault.Store).Export(new(bytes.Buffer), nil)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to decrypt account")
+}
+
+// corruptArchive rewrites an archive with the last byte of each entry other than the manifest and its MAC flipped.
+func corruptArchive(t *testing.T, data []byte) []byte {
+	return rewriteArchive(t, data, func(name string, entry []byte) []byte {
+		if name != "manifest.json" && name != "manifest.mac" {
+			entry[len(entry)-1] ^= 0xff
+		}
+		return entry
+	})
+}
+
+// rewriteArchive rewrites each entry of an archive, dropping those rewritten as nil.
+func rewriteArchive(t *testing.T, data []byte, rewrite func(name string, entry []byte) []byte) []byte {
+	out := new(bytes.Buffer)
+	tr := tar.NewReader(bytes.NewReader(data))
+	tw := tar.NewWriter(out)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		entry, err := ioutil.ReadAll(tr)
+		require.Nil(t, err)
+		entry = rewrite(header.Name, entry)
+		if entry == nil {
+			continue
+		}
+		header.Size = int64(len(entry))
+		require.Nil(t, tw.WriteHeader(header))
+		_, err = tw.Write(entry)
+		require.Nil(t, err)
+	}
+	require.Nil(t, tw.Close())
+	return out.Bytes()
+}
@@ -0,0 +1,160 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Audit actions.
+const (
+	// AuditRead is a wallet or account read from Vault.
+	AuditRead = "read"
+	// AuditWrite is a wallet or account written to Vault.
+	AuditWrite = "write"
+	// AuditDelete is a wallet or account deleted from Vault.
+	AuditDelete = "delete"
+)
+
+// AuditResultSuccess is the result of an access that succeeded.  Failed accesses have the type of their error as the
+// result, as reported to metrics.
+const AuditResultSuccess = "success"
+
+// AuditEvent is an access to a wallet or account.
+type AuditEvent struct {
+	// Time is the time of the access.
+	Time time.Time `json:"time"`
+	// Operation is the store operation that made the access, such as "retrieve_accounts".
+	Operation string `json:"operation"`
+	// Action is the access made, "read", "write" or "delete".
+	Action string `json:"action"`
+	// WalletID is the ID of the wallet that was accessed, or that holds the account that was accessed.
+	WalletID uuid.UUID `json:"wallet_id"`
+	// AccountID is the ID of the account that was accessed, or nil for a wallet.
+	AccountID uuid.UUID `json:"account_id"`
+	// RequestID is the ID of the Vault request that made the access.  Vault does not return an ID for all requests,
+	// for example writes to KV version 1 and deletes, so it can be empty.
+	RequestID string `json:"request_id,omitempty"`
+	// Accessor is the accessor of the Vault token that made the access.
+	Accessor string `json:"accessor,omitempty"`
+	// Host is the name of the host running the process that made the access.
+	Host string `json:"host"`
+	// PID is the ID of the process that made the access.
+	PID int `json:"pid"`
+	// Result is "success", or the type of error if the access failed.
+	Result string `json:"result"`
+}
+
+// AuditSink receives audit events.  Implementations must be safe for concurrent use.
+type AuditSink interface {
+	// Audit records an event.  It is called as each wallet or account is accessed, so should return promptly.
+	Audit(event *AuditEvent) error
+}
+
+// JSONLinesAuditSink writes audit events as JSON, one event per line.
+type JSONLinesAuditSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONLinesAuditSink creates an audit sink that writes events to the given writer.
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// Audit writes an event as a single line.
+func (s *JSONLinesAuditSink) Audit(event *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(event)
+}
+
+// openAuditFile opens a file to which audit events are appended, creating it if required.
+func openAuditFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit file")
+	}
+	return file, nil
+}
+
+// audit records an access to a wallet or account, made by the operation in the context, with the audit sink.
+// A failure to record the access is logged, but does not fail the access.  Accesses to indices, which hold no keys,
+// are not recorded.
+func (s *Store) audit(ctx context.Context, action string, binding *secretBinding, err error) {
+	if s.audit_sink == nil || (binding.kind != secretKindWallet && binding.kind != secretKindAccount) {
+		return
+	}
+	event := &AuditEvent{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		WalletID:  binding.walletID,
+		AccountID: binding.accountID,
+		Accessor:  s.tokenAccessor(),
+		Host:      s.host,
+		PID:       os.Getpid(),
+		Result:    AuditResultSuccess,
+	}
+	if op, exists := ctx.Value(operationKey{}).(*operation); exists {
+		event.Operation = op.name
+		event.RequestID = op.requestID
+	}
+	if errType := errorType(err); errType != "" {
+		event.Result = errType
+	}
+	if err := s.audit_sink.Audit(event); err != nil {
+		s.logger.Error("failed to record audit event", "operation", event.Operation, "action", action, "error", err)
+	}
+}
+
+// tokenAccessor returns the accessor of the store's Vault token.
+func (s *Store) tokenAccessor() string {
+	s.accessorMu.RLock()
+	defer s.accessorMu.RUnlock()
+	return s.accessor
+}
+
+// setTokenAccessor sets the accessor of the store's Vault token following a login, looking it up if the auth method
+// did not return it.  It is only required for auditing.
+func (s *Store) setTokenAccessor(ctx context.Context, secret *vault.Secret) {
+	if s.audit_sink == nil {
+		return
+	}
+	accessor := ""
+	if secret != nil && secret.Auth != nil {
+		accessor = secret.Auth.Accessor
+	}
+	if accessor == "" {
+		self, err := s.client.Auth().Token().LookupSelfWithContext(ctx)
+		if err != nil {
+			s.logger.Warn("failed to look up Vault token accessor for audit events", "error", err)
+		} else if self != nil {
+			accessor, _ = self.TokenAccessor()
+		}
+	}
+	s.accessorMu.Lock()
+	s.accessor = accessor
+	s.accessorMu.Unlock()
+}
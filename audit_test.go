@@ -0,0 +1,178 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditFile(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithAuditFile(auditFile),
+	)
+	require.Nil(t, err)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "audit wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"audit wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"audit account"}`, accountID))))
+	_, err = store.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	missingID := uuid.New()
+	_, err = store.RetrieveAccount(walletID, missingID)
+	require.NotNil(t, err)
+	require.Nil(t, store.(*vault.Store).DeleteAccount(walletID, accountID))
+	require.Nil(t, store.(*vault.Store).Close())
+
+	file, err := os.Open(auditFile)
+	require.Nil(t, err)
+	defer file.Close()
+	events := make(map[string]*vault.AuditEvent)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		event := &vault.AuditEvent{}
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), event))
+		if event.WalletID != walletID {
+			continue
+		}
+		assert.Equal(t, "fake-accessor", event.Accessor)
+		assert.Equal(t, os.Getpid(), event.PID)
+		assert.False(t, event.Time.IsZero())
+		events[fmt.Sprintf("%s %s %s", event.Operation, event.Action, event.AccountID)] = event
+	}
+	require.Nil(t, scanner.Err())
+
+	event := events[fmt.Sprintf("store_wallet write %s", uuid.Nil)]
+	require.NotNil(t, event)
+	assert.Equal(t, vault.AuditResultSuccess, event.Result)
+	assert.NotEmpty(t, event.RequestID)
+	event = events[fmt.Sprintf("store_account write %s", accountID)]
+	require.NotNil(t, event)
+	assert.Equal(t, vault.AuditResultSuccess, event.Result)
+	event = events[fmt.Sprintf("retrieve_account read %s", accountID)]
+	require.NotNil(t, event)
+	assert.Equal(t, vault.AuditResultSuccess, event.Result)
+	assert.NotEmpty(t, event.RequestID)
+	event = events[fmt.Sprintf("retrieve_account read %s", missingID)]
+	require.NotNil(t, event)
+	assert.Equal(t, vault.ErrorTypeNotFound, event.Result)
+	event = events[fmt.Sprintf("delete_account delete %s", accountID)]
+	require.NotNil(t, event)
+	assert.Equal(t, vault.AuditResultSuccess, event.Result)
+}
+
+// recordingAuditSink records the events it receives.
+type recordingAuditSink struct {
+	events []*vault.AuditEvent
+}
+
+func (s *recordingAuditSink) Audit(event *vault.AuditEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestAuditSink(t *testing.T) {
+	sink := &recordingAuditSink{}
+	store, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithAuditSink(sink),
+	)
+	require.Nil(t, err)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "audit sink wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"audit sink wallet"}`, walletID))))
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, "store_wallet", sink.events[0].Operation)
+	assert.Equal(t, vault.AuditWrite, sink.events[0].Action)
+	assert.Equal(t, walletID, sink.events[0].WalletID)
+
+	_, err = vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithAuditSink(sink),
+		vault.WithAuditFile(filepath.Join(t.TempDir(), "audit.log")),
+	)
+	assert.NotNil(t, err)
+}
+
+func TestAuditMaintenance(t *testing.T) {
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	sink := &recordingAuditSink{}
+	newStore := func(opts ...vault.Option) *vault.Store {
+		store, err := vault.New(append([]vault.Option{
+			vault.WithPassphrase([]byte("test")),
+			vault.WithVaultAddr("http://localhost:8200"),
+			vault.WithVaultSecretMountPath(mount),
+			vault.WithVaultToken("golang-test"),
+			vault.WithVaultAuth("token"),
+			vault.WithAuditSink(sink),
+		}, opts...)...)
+		require.Nil(t, err)
+		return store.(*vault.Store)
+	}
+	store := newStore()
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "audit wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"audit wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"audit account"}`, accountID))))
+
+	// actions returns the actions recorded for the account since the given number of events, by operation.
+	actions := func(since int) map[string][]string {
+		res := make(map[string][]string)
+		for _, event := range sink.events[since:] {
+			if event.AccountID == accountID {
+				res[event.Operation] = append(res[event.Operation], event.Action)
+			}
+		}
+		return res
+	}
+
+	since := len(sink.events)
+	_, err := store.Verify(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{"verify": {vault.AuditRead}}, actions(since))
+
+	since = len(sink.events)
+	_, err = store.UpgradeEnvelopes()
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{"upgrade_envelopes": {vault.AuditRead}}, actions(since))
+
+	since = len(sink.events)
+	_, err = newStore(vault.WithAccountLayout(vault.AccountLayoutSharded)).MigrateAccountLayout()
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{"migrate_account_layout": {vault.AuditRead, vault.AuditWrite, vault.AuditDelete}}, actions(since))
+}
@@ -0,0 +1,199 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	approle "github.com/hashicorp/vault/api/auth/approle"
+	kubernetes "github.com/hashicorp/vault/api/auth/kubernetes"
+	"github.com/pkg/errors"
+)
+
+// loginRetryInterval is the time to wait before retrying a failed login.
+const loginRetryInterval = 10 * time.Second
+
+// AuthMethod authenticates the store with Vault.
+// Any auth method from github.com/hashicorp/vault/api/auth satisfies this interface, so can be supplied directly to WithAuthMethod().
+type AuthMethod interface {
+	// Login logs in to Vault, returning a secret containing the client token.
+	Login(ctx context.Context, client *vault.Client) (*vault.Secret, error)
+}
+
+// tokenAuth authenticates with a static token.
+type tokenAuth struct {
+	token string
+}
+
+// NewTokenAuth creates an auth method that uses a static Vault token.
+func NewTokenAuth(token string) (AuthMethod, error) {
+	if token == "" {
+		return nil, errors.New("vault_token option missing")
+	}
+	return &tokenAuth{token: token}, nil
+}
+
+// Login returns the static token.
+func (a *tokenAuth) Login(_ context.Context, _ *vault.Client) (*vault.Secret, error) {
+	return &vault.Secret{
+		Auth: &vault.SecretAuth{
+			ClientToken: a.token,
+		},
+	}, nil
+}
+
+// NewKubernetesAuth creates an auth method that logs in with a Kubernetes service account token.
+// If saTokenPath is empty the standard service account token path is used; if mountPath is empty "kubernetes" is used.
+func NewKubernetesAuth(role string, saTokenPath string, mountPath string) (AuthMethod, error) {
+	if role == "" {
+		return nil, errors.New("vault_k8s_auth_role option missing")
+	}
+	opts := make([]kubernetes.LoginOption, 0)
+	if saTokenPath != "" {
+		opts = append(opts, kubernetes.WithServiceAccountTokenPath(saTokenPath))
+	}
+	if mountPath != "" {
+		opts = append(opts, kubernetes.WithMountPath(mountPath))
+	}
+	return kubernetes.NewKubernetesAuth(role, opts...)
+}
+
+// NewAppRoleAuth creates an auth method that logs in with an AppRole role ID and secret ID.
+// If mountPath is empty "approle" is used.
+func NewAppRoleAuth(roleID string, secretID string, mountPath string) (AuthMethod, error) {
+	if roleID == "" {
+		return nil, errors.New("role ID missing")
+	}
+	if secretID == "" {
+		return nil, errors.New("secret ID missing")
+	}
+	opts := make([]approle.LoginOption, 0)
+	if mountPath != "" {
+		opts = append(opts, approle.WithMountPath(mountPath))
+	}
+	return approle.NewAppRoleAuth(roleID, &approle.SecretID{FromString: secretID}, opts...)
+}
+
+// authMethodFromOptions obtains the auth method for the given options.
+func authMethodFromOptions(options *options) (AuthMethod, error) {
+	if options.auth_method != nil {
+		return options.auth_method, nil
+	}
+
+	switch options.vault_auth {
+	case "":
+		return nil, errors.New("vault_auth option missing")
+	case "token":
+		return NewTokenAuth(options.vault_token)
+	case "kubernetes":
+		// The service-account token will be read from the path where the token's
+		// Kubernetes Secret is mounted. By default, Kubernetes will mount it to
+		// /var/run/secrets/kubernetes.io/serviceaccount/token, but an administrator
+		// may have configured it to be mounted elsewhere.
+		return NewKubernetesAuth(options.vault_k8s_auth_role, options.vault_k8s_auth_sa_token_path, options.vault_k8s_auth_mount_path)
+	default:
+		return nil, fmt.Errorf("unsupported vault_auth %q", options.vault_auth)
+	}
+}
+
+// login logs in to Vault with the store's auth method, setting the client token.
+func (s *Store) login(ctx context.Context) (*vault.Secret, error) {
+	ctx, req := s.startRequest(ctx, "login", "")
+	secret, err := s.client.Auth().Login(ctx, s.auth)
+	req.end(err)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to log in to vault")
+	}
+	for _, readClient := range s.readClients {
+		readClient.SetToken(s.client.Token())
+	}
+	if secret != nil && secret.Auth != nil {
+		s.logger.Info("logged in to Vault", "auth", s.vault_auth, "ttl", time.Duration(secret.Auth.LeaseDuration)*time.Second, "renewable", secret.Auth.Renewable)
+	}
+	return secret, nil
+}
+
+// maintainToken keeps the client token obtained by login alive, renewing it where possible and
+// logging in again when it can no longer be renewed, until the store is closed.
+func (s *Store) maintainToken(secret *vault.Secret) {
+	defer s.maintenance.Done()
+	for {
+		if secret.Auth == nil || secret.Auth.LeaseDuration == 0 {
+			// Token does not expire.
+			return
+		}
+
+		watcher, err := s.client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{Secret: secret})
+		if err != nil {
+			s.logger.Error("failed to watch Vault token", "error", err)
+			return
+		}
+		go watcher.Start()
+		watching := true
+		for watching {
+			select {
+			case err := <-watcher.DoneCh():
+				if err != nil {
+					s.logger.Warn("failed to renew Vault token", "error", err)
+					s.metrics.TokenRenewal(false)
+				}
+				watching = false
+			case renewal := <-watcher.RenewCh():
+				if renewal.Secret != nil && renewal.Secret.Auth != nil {
+					s.logger.Debug("renewed Vault token", "ttl", time.Duration(renewal.Secret.Auth.LeaseDuration)*time.Second)
+				}
+				s.metrics.TokenRenewal(true)
+			case <-s.done:
+				watcher.Stop()
+				return
+			}
+		}
+		watcher.Stop()
+
+		// The token can no longer be renewed, so log in again.
+		s.logger.Info("Vault token can no longer be renewed, logging in again")
+		for {
+			secret, err = s.login(context.Background())
+			if err == nil {
+				break
+			}
+			s.logger.Warn("failed to log in to Vault", "error", err, "retry_in", loginRetryInterval)
+			select {
+			case <-time.After(loginRetryInterval):
+				s.metrics.Retry("login")
+			case <-s.done:
+				return
+			}
+		}
+		s.setTokenAccessor(context.Background(), secret)
+		select {
+		case <-s.done:
+			// Closed while logging in.
+			s.clearToken()
+			return
+		default:
+		}
+	}
+}
+
+// clearToken clears the Vault token from the store's clients.
+func (s *Store) clearToken() {
+	s.client.ClearToken()
+	for _, readClient := range s.readClients {
+		readClient.ClearToken()
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticAuth is a custom auth method that returns a fixed token or error.
+type staticAuth struct {
+	token string
+	err   error
+}
+
+func (a *staticAuth) Login(_ context.Context, _ *vaultapi.Client) (*vaultapi.Secret, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &vaultapi.Secret{Auth: &vaultapi.SecretAuth{ClientToken: a.token}}, nil
+}
+
+func TestAuthMethods(t *testing.T) {
+	tokenAuth, err := vault.NewTokenAuth("golang-test")
+	require.Nil(t, err)
+	appRoleAuth, err := vault.NewAppRoleAuth("test-role", "test-secret", "")
+	require.Nil(t, err)
+	badAppRoleAuth, err := vault.NewAppRoleAuth("test-role", "bad-secret", "")
+	require.Nil(t, err)
+
+	tests := []struct {
+		name string
+		opts []vault.Option
+		err  string
+	}{
+		{
+			name: "AuthMissing",
+			err:  "vault_auth option missing",
+		},
+		{
+			name: "Unsupported",
+			opts: []vault.Option{vault.WithVaultAuth("approle")},
+			err:  `unsupported vault_auth "approle"`,
+		},
+		{
+			name: "TokenMissing",
+			opts: []vault.Option{vault.WithVaultAuth("token")},
+			err:  "vault_token option missing",
+		},
+		{
+			name: "KubernetesRoleMissing",
+			opts: []vault.Option{vault.WithVaultAuth("kubernetes")},
+			err:  "vault_k8s_auth_role option missing",
+		},
+		{
+			name: "Token",
+			opts: []vault.Option{vault.WithAuthMethod(tokenAuth)},
+		},
+		{
+			name: "AuthMethodOverridesVaultAuth",
+			opts: []vault.Option{vault.WithVaultAuth("approle"), vault.WithAuthMethod(tokenAuth)},
+		},
+		{
+			name: "AppRole",
+			opts: []vault.Option{vault.WithAuthMethod(appRoleAuth)},
+		},
+		{
+			name: "AppRoleBadSecret",
+			opts: []vault.Option{vault.WithAuthMethod(badAppRoleAuth)},
+			err:  "failed to log in to vault",
+		},
+		{
+			name: "Custom",
+			opts: []vault.Option{vault.WithAuthMethod(&staticAuth{token: "golang-test"})},
+		},
+		{
+			name: "CustomFailure",
+			opts: []vault.Option{vault.WithAuthMethod(&staticAuth{err: errors.New("no credentials")})},
+			err:  "failed to log in to vault",
+		},
+		{
+			name: "CustomNoToken",
+			opts: []vault.Option{vault.WithAuthMethod(&staticAuth{})},
+			err:  "failed to log in to vault",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := append([]vault.Option{
+				vault.WithVaultAddr("http://localhost:8200"),
+				vault.WithVaultSecretMountPath("secret"),
+			}, test.opts...)
+			store, err := vault.New(opts...)
+			if test.err != "" {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), test.err)
+			} else {
+				require.Nil(t, err)
+				assert.Equal(t, "vault", store.Name())
+			}
+		})
+	}
+}
+
+func TestNewAuthMethodParams(t *testing.T) {
+	_, err := vault.NewTokenAuth("")
+	assert.NotNil(t, err)
+	_, err = vault.NewKubernetesAuth("", "", "")
+	assert.NotNil(t, err)
+	_, err = vault.NewAppRoleAuth("", "test-secret", "")
+	assert.NotNil(t, err)
+	_, err = vault.NewAppRoleAuth("test-role", "", "")
+	assert.NotNil(t, err)
+}
+
+// expiringAuth is a custom auth method that counts logins, returning a short-lived token that cannot be renewed.
+type expiringAuth struct {
+	logins int32
+}
+
+func (a *expiringAuth) Login(_ context.Context, _ *vaultapi.Client) (*vaultapi.Secret, error) {
+	atomic.AddInt32(&a.logins, 1)
+	return &vaultapi.Secret{Auth: &vaultapi.SecretAuth{ClientToken: "golang-test", LeaseDuration: 1}}, nil
+}
+
+func TestCloseStopsTokenMaintenance(t *testing.T) {
+	auth := &expiringAuth{}
+	store, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithAuthMethod(auth),
+	)
+	require.Nil(t, err)
+
+	// The token cannot be renewed, so the store logs in again.
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&auth.logins) >= 2 }, 10*time.Second, 10*time.Millisecond)
+	require.Nil(t, store.(*vault.Store).Close())
+	logins := atomic.LoadInt32(&auth.logins)
+	time.Sleep(2500 * time.Millisecond)
+	assert.Equal(t, logins, atomic.LoadInt32(&auth.logins))
+}
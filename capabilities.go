@@ -0,0 +1,214 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// pathCapabilities are the capabilities required on a Vault path.
+type pathCapabilities struct {
+	// path is the path as it appears in a Vault policy, which may end in a glob.
+	path string
+	// probe is a concrete path matched by path, used to check capabilities.
+	probe        string
+	capabilities []string
+}
+
+// MissingCapability is a capability the client token requires but does not have.
+type MissingCapability struct {
+	Path       string
+	Capability string
+}
+
+// MissingCapabilitiesError is returned when the client token does not have the capabilities the store requires.
+type MissingCapabilitiesError struct {
+	Missing []*MissingCapability
+}
+
+// Error implements the error interface.
+func (e *MissingCapabilitiesError) Error() string {
+	byPath := make(map[string][]string)
+	paths := make([]string, 0)
+	for _, missing := range e.Missing {
+		if _, exists := byPath[missing.Path]; !exists {
+			paths = append(paths, missing.Path)
+		}
+		byPath[missing.Path] = append(byPath[missing.Path], fmt.Sprintf("%q", missing.Capability))
+	}
+	descriptions := make([]string, len(paths))
+	for i, path := range paths {
+		descriptions[i] = fmt.Sprintf("%s on %s", strings.Join(byPath[path], ", "), path)
+	}
+	return fmt.Sprintf("vault token is missing capabilities: %s", strings.Join(descriptions, "; "))
+}
+
+// requiredCapabilities returns the capabilities the store requires on each path it uses for the given access.
+func (s *Store) requiredCapabilities(access PolicyAccess) []*pathCapabilities {
+	// Representative wallet and account IDs for probing globbed paths.
+	walletPath := fmt.Sprintf("wallets/%s", uuid.Nil.String())
+	accountPath := fmt.Sprintf("%s/%s", walletPath, uuid.Nil.String())
+
+	dataCapabilities := []string{"create", "read", "update"}
+	metadataCapabilities := []string{"list"}
+	if access == PolicyReadOnly {
+		dataCapabilities = []string{"read"}
+	}
+	if access == PolicyReadWriteDelete {
+		metadataCapabilities = append(metadataCapabilities, "delete")
+	}
+	if s.custom_metadata {
+		// Reading and writing custom metadata.
+		metadataCapabilities = append(metadataCapabilities, "read")
+		if access != PolicyReadOnly {
+			metadataCapabilities = append(metadataCapabilities, "create", "update")
+		}
+	}
+
+	required := []*pathCapabilities{
+		{
+			// Listing wallets.
+			path:         s.kvMetadataPath("wallets"),
+			probe:        s.kvMetadataPath("wallets"),
+			capabilities: []string{"list"},
+		},
+		{
+			// Listing accounts, deleting wallets and accounts, and custom metadata.
+			path:         s.kvMetadataPath("wallets/*"),
+			probe:        s.kvMetadataPath(walletPath),
+			capabilities: metadataCapabilities,
+		},
+		{
+			// Reading and writing wallets, accounts and indices.
+			path:         s.kvDataPath("wallets/*"),
+			probe:        s.kvDataPath(accountPath),
+			capabilities: dataCapabilities,
+		},
+		{
+			// Reading and writing the public key index.
+			path:         s.kvDataPath(s.publicKeyIndexPath()),
+			probe:        s.kvDataPath(s.publicKeyIndexPath()),
+			capabilities: dataCapabilities,
+		},
+	}
+
+	return mergePathCapabilities(required)
+}
+
+// mergePathCapabilities merges capabilities for identical paths, as happens with KV version 1 where data and metadata
+// share a path.
+func mergePathCapabilities(required []*pathCapabilities) []*pathCapabilities {
+	merged := make([]*pathCapabilities, 0, len(required))
+	byPath := make(map[string]*pathCapabilities)
+	for _, req := range required {
+		existing, exists := byPath[req.path]
+		if !exists {
+			existing = &pathCapabilities{
+				path:  req.path,
+				probe: req.probe,
+			}
+			byPath[req.path] = existing
+			merged = append(merged, existing)
+		}
+		for _, capability := range req.capabilities {
+			if !hasExactCapability(existing.capabilities, capability) {
+				existing.capabilities = append(existing.capabilities, capability)
+			}
+		}
+	}
+	return merged
+}
+
+// hasExactCapability returns true if the capabilities include the given capability, ignoring root.
+func hasExactCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// preflight checks that the client token has all of the capabilities required by the store.
+// Write capabilities are not required if the store is read-only.  Deleting wallets and accounts is optional, so
+// capabilities required only for deletion are logged as a warning if missing rather than failing the check.
+func (s *Store) preflight(ctx context.Context) error {
+	essential := PolicyReadWrite
+	if s.read_only {
+		essential = PolicyReadOnly
+	}
+	essentialCapabilities := s.requiredCapabilities(essential)
+
+	missing := make([]*MissingCapability, 0)
+	for _, required := range s.requiredCapabilities(s.access()) {
+		capabilities, err := s.client.Sys().CapabilitiesSelfWithContext(ctx, required.probe)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to obtain capabilities for %s", required.path))
+		}
+		for _, capability := range required.capabilities {
+			if hasCapability(capabilities, capability) {
+				continue
+			}
+			if !requiresCapability(essentialCapabilities, required.path, capability) {
+				s.logger.Warn("vault token is missing optional capability", "path", required.path, "capability", capability)
+				continue
+			}
+			missing = append(missing, &MissingCapability{
+				Path:       required.path,
+				Capability: capability,
+			})
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingCapabilitiesError{Missing: missing}
+	}
+	return nil
+}
+
+// RequiredPolicy returns the minimal Vault policy, in HCL, that allows the store to operate, including deleting
+// wallets and accounts.  If the store is read-only the policy does not allow writes.
+func (s *Store) RequiredPolicy() string {
+	return renderPolicy(s.requiredCapabilities(s.access()))
+}
+
+// requiresCapability returns true if the capability is required on the given path.
+func requiresCapability(required []*pathCapabilities, path string, capability string) bool {
+	for _, req := range required {
+		if req.path == path && hasExactCapability(req.capabilities, capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderPolicy renders capabilities as an HCL Vault policy.
+func renderPolicy(required []*pathCapabilities) string {
+	var builder strings.Builder
+	for i, req := range required {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		capabilities := make([]string, len(req.capabilities))
+		for j, capability := range req.capabilities {
+			capabilities[j] = fmt.Sprintf("%q", capability)
+		}
+		builder.WriteString(fmt.Sprintf("path %q {\n  capabilities = [%s]\n}\n", req.path, strings.Join(capabilities, ", ")))
+	}
+	return builder.String()
+}
@@ -0,0 +1,153 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"errors"
+	"testing"
+
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflight(t *testing.T) {
+	_, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithPreflight(),
+	)
+	require.Nil(t, err)
+}
+
+func TestPreflightMissingCapabilities(t *testing.T) {
+	token := createPolicyToken(t, "wallets-preflight-partial", `
+path "secret/metadata/wallets" {
+  capabilities = ["list"]
+}
+path "secret/data/wallets/*" {
+  capabilities = ["read"]
+}
+`)
+	logger := &recordingLogger{}
+	_, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultKVVersion(2),
+		vault.WithVaultToken(token),
+		vault.WithVaultAuth("token"),
+		vault.WithPreflight(),
+		vault.WithLogger(logger),
+	)
+	require.NotNil(t, err)
+
+	var missingErr *vault.MissingCapabilitiesError
+	require.True(t, errors.As(err, &missingErr))
+	assert.Equal(t, []*vault.MissingCapability{
+		{Path: "secret/metadata/wallets/*", Capability: "list"},
+		{Path: "secret/data/wallets/*", Capability: "create"},
+		{Path: "secret/data/wallets/*", Capability: "update"},
+		{Path: "secret/data/indices/pubkeys", Capability: "create"},
+		{Path: "secret/data/indices/pubkeys", Capability: "read"},
+		{Path: "secret/data/indices/pubkeys", Capability: "update"},
+	}, missingErr.Missing)
+	assert.Equal(t, `vault token is missing capabilities: "list" on secret/metadata/wallets/*; "create", "update" on secret/data/wallets/*; "create", "read", "update" on secret/data/indices/pubkeys`, err.Error())
+	// Delete is optional.
+	assert.Len(t, logger.find("vault token is missing optional capability"), 1)
+}
+
+func TestPreflightWithoutDelete(t *testing.T) {
+	policy, err := vault.GeneratePolicy(vault.PolicyReadWrite, vault.WithVaultSecretMountPath("secret"))
+	require.Nil(t, err)
+	token := createPolicyToken(t, "wallets-preflight-no-delete", policy)
+
+	logger := &recordingLogger{}
+	_, err = vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultKVVersion(2),
+		vault.WithVaultToken(token),
+		vault.WithVaultAuth("token"),
+		vault.WithPreflight(),
+		vault.WithLogger(logger),
+	)
+	require.Nil(t, err)
+	assert.Len(t, logger.find("vault token is missing optional capability"), 1)
+}
+
+func TestRequiredPolicy(t *testing.T) {
+	store, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+
+	policy := store.(*vault.Store).RequiredPolicy()
+	assert.Equal(t, `path "secret/metadata/wallets" {
+  capabilities = ["list"]
+}
+
+path "secret/metadata/wallets/*" {
+  capabilities = ["list", "delete"]
+}
+
+path "secret/data/wallets/*" {
+  capabilities = ["create", "read", "update"]
+}
+
+path "secret/data/indices/pubkeys" {
+  capabilities = ["create", "read", "update"]
+}
+`, policy)
+
+	// A token with the required policy should pass preflight.
+	token := createPolicyToken(t, "wallets-preflight-required", policy)
+	_, err = vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultKVVersion(2),
+		vault.WithVaultToken(token),
+		vault.WithVaultAuth("token"),
+		vault.WithPreflight(),
+	)
+	require.Nil(t, err)
+}
+
+func TestRequiredPolicyKVv1(t *testing.T) {
+	mountKVv1(t, "kv1")
+	store, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("kv1"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+
+	assert.Equal(t, `path "kv1/wallets" {
+  capabilities = ["list"]
+}
+
+path "kv1/wallets/*" {
+  capabilities = ["list", "delete", "create", "read", "update"]
+}
+
+path "kv1/indices/pubkeys" {
+  capabilities = ["create", "read", "update"]
+}
+`, store.(*vault.Store).RequiredPolicy())
+}
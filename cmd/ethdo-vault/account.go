@@ -0,0 +1,231 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	indexer "github.com/wealdtech/go-indexer"
+)
+
+var accountCommands = map[string]*command{
+	"list": {
+		description: "list the accounts in a wallet",
+		run:         runAccountList,
+	},
+	"info": {
+		description: "show account metadata",
+		run:         runAccountInfo,
+	},
+	"store": {
+		description: "store an account from a JSON file",
+		run:         runAccountStore,
+	},
+	"delete": {
+		description: "delete an account",
+		run:         runAccountDelete,
+	},
+	"import": {
+		description: "import a directory of EIP-2335 keystores in to a non-deterministic wallet",
+		run:         runAccountImport,
+	},
+}
+
+// runAccountList lists the accounts in a wallet.
+func runAccountList(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("account list", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	wallet := flags.String("wallet", "", "name or ID of the wallet")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	walletInfo, err := findWallet(store, *wallet)
+	if err != nil {
+		return err
+	}
+	cursor := ""
+	for {
+		page, err := store.ListAccounts(context.Background(), walletInfo.ID, cursor, listPageSize)
+		if err != nil {
+			return err
+		}
+		for _, data := range page.Items {
+			accountInfo, err := parseInfo(data)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\n", accountInfo.ID, accountInfo.Name, accountInfo.PubKey)
+		}
+		if page.Cursor == "" {
+			return nil
+		}
+		cursor = page.Cursor
+	}
+}
+
+// runAccountInfo shows account metadata.
+func runAccountInfo(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("account info", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	wallet := flags.String("wallet", "", "name or ID of the wallet")
+	account := flags.String("account", "", "name or ID of the account")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	walletInfo, err := findWallet(store, *wallet)
+	if err != nil {
+		return err
+	}
+	data, err := findAccount(store, walletInfo.ID, *account)
+	if err != nil {
+		return err
+	}
+	return writeMetadata(out, data)
+}
+
+// runAccountStore stores an account from a JSON file, adding it to the wallet's accounts index.
+func runAccountStore(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("account store", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	wallet := flags.String("wallet", "", "name or ID of the wallet")
+	file := flags.String("file", "", "path to the account JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("--file is required")
+	}
+	// #nosec G304
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	accountInfo, err := parseInfo(data)
+	if err != nil {
+		return fmt.Errorf("invalid account: %v", err)
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	walletInfo, err := findWallet(store, *wallet)
+	if err != nil {
+		return err
+	}
+	if existing, err := findAccount(store, walletInfo.ID, accountInfo.Name); err == nil {
+		if existingInfo, err := parseInfo(existing); err == nil && existingInfo.ID != accountInfo.ID {
+			return fmt.Errorf("account %q already exists with ID %s", accountInfo.Name, existingInfo.ID)
+		}
+	}
+	if err := store.StoreAccount(walletInfo.ID, accountInfo.ID, data); err != nil {
+		return err
+	}
+	if err := updateIndex(store, walletInfo.ID, func(index *indexer.Index) {
+		index.Add(accountInfo.ID, accountInfo.Name)
+	}); err != nil {
+		return fmt.Errorf("account stored but failed to update accounts index: %v", err)
+	}
+	fmt.Fprintf(out, "%s\t%s\n", accountInfo.ID, accountInfo.Name)
+	return nil
+}
+
+// runAccountDelete deletes an account, removing it from the wallet's accounts index.
+func runAccountDelete(args []string, _ io.Writer) error {
+	flags := flag.NewFlagSet("account delete", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	wallet := flags.String("wallet", "", "name or ID of the wallet")
+	account := flags.String("account", "", "name or ID of the account")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	walletInfo, err := findWallet(store, *wallet)
+	if err != nil {
+		return err
+	}
+	data, err := findAccount(store, walletInfo.ID, *account)
+	if err != nil {
+		return err
+	}
+	accountInfo, err := parseInfo(data)
+	if err != nil {
+		return err
+	}
+	if err := store.DeleteAccount(walletInfo.ID, accountInfo.ID); err != nil {
+		return err
+	}
+	if err := updateIndex(store, walletInfo.ID, func(index *indexer.Index) {
+		index.Remove(accountInfo.ID, accountInfo.Name)
+	}); err != nil {
+		return fmt.Errorf("account deleted but failed to update accounts index: %v", err)
+	}
+	return nil
+}
+
+// runAccountImport imports a directory of EIP-2335 keystores, creating the wallet if required.
+func runAccountImport(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("account import", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	wallet := flags.String("wallet", "", "name of the wallet; created if it does not exist")
+	dir := flags.String("dir", "", "path to the directory of keystores")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *wallet == "" {
+		return errors.New("--wallet is required")
+	}
+	if *dir == "" {
+		return errors.New("--dir is required")
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := store.ImportKeystores(*dir, *wallet)
+	if report != nil {
+		for _, name := range report.Imported {
+			fmt.Fprintf(out, "imported\t%s\n", name)
+		}
+		for _, path := range report.Duplicates {
+			fmt.Fprintf(out, "duplicate\t%s\n", path)
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccount(t *testing.T) {
+	setStoreEnv(t)
+	walletID := uuid.New()
+	_, err := run("wallet", "store", "--file", writeFile(t, "wallet.json", fmt.Sprintf(`{"uuid":%q,"name":"cli wallet"}`, walletID)))
+	require.Nil(t, err)
+
+	accountID := uuid.New()
+	file := writeFile(t, "account.json", fmt.Sprintf(`{"uuid":%q,"name":"cli account","pubkey":"a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c","crypto":{"secret":"value"}}`, accountID))
+	out, err := run("account", "store", "--wallet", "cli wallet", "--file", file)
+	require.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("%s\tcli account\n", accountID), out)
+
+	out, err = run("account", "list", "--wallet", "cli wallet")
+	require.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("%s\tcli account\ta99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c\n", accountID), out)
+
+	out, err = run("account", "info", "--wallet", walletID.String(), "--account", "cli account")
+	require.Nil(t, err)
+	assert.Contains(t, out, accountID.String())
+	assert.NotContains(t, out, "secret")
+
+	_, err = run("account", "delete", "--wallet", "cli wallet", "--account", accountID.String())
+	require.Nil(t, err)
+	out, err = run("account", "list", "--wallet", "cli wallet")
+	require.Nil(t, err)
+	assert.Empty(t, out)
+}
+
+func TestAccountImport(t *testing.T) {
+	setStoreEnv(t)
+	dir := t.TempDir()
+	for name, pubKey := range map[string]string{
+		"keystore-0": "a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c",
+		"keystore-1": "b89bebc699769726a318c8e9971bd3171297c61aea4a6578a7a4f94b547dcba5bac16a89108b6b6a1fe3695d1a874a0b",
+	} {
+		data := fmt.Sprintf(`{"crypto":{"kdf":{"function":"pbkdf2"},"checksum":{"function":"sha256"},"cipher":{"function":"aes-128-ctr"}},"pubkey":%q,"uuid":%q,"version":4}`, pubKey, uuid.New())
+		require.Nil(t, ioutil.WriteFile(filepath.Join(dir, name+".json"), []byte(data), 0600))
+	}
+
+	out, err := run("account", "import", "--wallet", "keystore wallet", "--dir", dir)
+	require.Nil(t, err)
+	assert.Equal(t, "imported\tkeystore-0\nimported\tkeystore-1\n", out)
+
+	out, err = run("account", "import", "--wallet", "keystore wallet", "--dir", dir)
+	require.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(out, "duplicate\t"))
+
+	out, err = run("account", "list", "--wallet", "keystore wallet")
+	require.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(out, "\n"))
+}
@@ -0,0 +1,87 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// ethdo-vault is a command-line tool for working with Ethereum 2 wallets held in a Vault store.
+//
+// The store is configured from the environment as described in vaultstorage.NewFromEnv, or from a configuration file
+// supplied with --config as described in vaultstorage.NewFromConfig.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a command or subcommand of the tool.
+type command struct {
+	description string
+	run         func(args []string, out io.Writer) error
+}
+
+var commands = map[string]*command{
+	"wallet": {
+		description: "list, show, store and delete wallets",
+		run:         subcommands("wallet", walletCommands),
+	},
+	"account": {
+		description: "list, show, store and delete accounts",
+		run:         subcommands("account", accountCommands),
+	},
+	"policy": {
+		description: "generate the Vault policy required by the store",
+		run:         runPolicy,
+	},
+	"verify": {
+		description: "check that every secret in the store can be read",
+		run:         runVerify,
+	},
+}
+
+func main() {
+	if err := subcommands(os.Args[0], commands)(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// subcommands returns a function that dispatches to one of the given commands.
+func subcommands(name string, cmds map[string]*command) func(args []string, out io.Writer) error {
+	return func(args []string, out io.Writer) error {
+		if len(args) == 0 {
+			return fmt.Errorf("no command supplied\n%s", usage(name, cmds))
+		}
+		cmd, exists := cmds[args[0]]
+		if !exists {
+			return fmt.Errorf("unknown command %q\n%s", args[0], usage(name, cmds))
+		}
+		return cmd.run(args[1:], out)
+	}
+}
+
+// usage describes the given commands.
+func usage(name string, cmds map[string]*command) string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("Usage: %s <command> [options]\n\nCommands:\n", name))
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		builder.WriteString(fmt.Sprintf("  %-10s %s\n", name, cmds[name].description))
+	}
+	return builder.String()
+}
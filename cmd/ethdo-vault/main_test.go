@@ -0,0 +1,73 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setStoreEnv configures the store from the environment to use a fresh KV version 2 mount, returning its path.
+func setStoreEnv(t *testing.T) string {
+	mount := fmt.Sprintf("ethdo-vault-%s", uuid.New())
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken("golang-test")
+	require.Nil(t, client.Sys().Mount(mount, &vaultapi.MountInput{
+		Type:    "kv",
+		Options: map[string]string{"version": "2"},
+	}))
+
+	t.Setenv("VAULT_ADDR", "http://localhost:8200")
+	t.Setenv("VAULT_TOKEN", "golang-test")
+	t.Setenv("VAULT_STORE_SECRETS_MOUNT_PATH", mount)
+	t.Setenv("VAULT_STORE_KV_VERSION", "2")
+	t.Setenv("VAULT_STORE_PASSPHRASE", "test")
+	return mount
+}
+
+// run runs the tool with the given arguments, returning its output.
+func run(args ...string) (string, error) {
+	out := new(bytes.Buffer)
+	err := subcommands("ethdo-vault", commands)(args, out)
+	return out.String(), err
+}
+
+// writeFile writes data to a file in a temporary directory, returning its path.
+func writeFile(t *testing.T, name string, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.Nil(t, ioutil.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestUnknownCommand(t *testing.T) {
+	_, err := run()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no command supplied")
+
+	_, err = run("wallets")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `unknown command "wallets"`)
+	assert.Contains(t, err.Error(), "verify")
+}
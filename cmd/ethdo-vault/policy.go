@@ -0,0 +1,56 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+)
+
+// runPolicy generates the Vault policy required by the store.
+func runPolicy(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("policy", flag.ContinueOnError)
+	mountPath := flags.String("mount", "", "KV secrets module path")
+	kvVersion := flags.Int("kv-version", 2, "KV secrets module version")
+	readOnly := flags.Bool("read-only", false, "generate a policy that does not allow writes")
+	noDelete := flags.Bool("no-delete", false, "generate a policy that does not allow wallets and accounts to be deleted")
+	customMetadata := flags.Bool("custom-metadata", false, "allow access to KV version 2 custom metadata")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	access := vault.PolicyReadWriteDelete
+	switch {
+	case *readOnly:
+		access = vault.PolicyReadOnly
+	case *noDelete:
+		access = vault.PolicyReadWrite
+	}
+	opts := []vault.Option{
+		vault.WithVaultSecretMountPath(*mountPath),
+		vault.WithVaultKVVersion(*kvVersion),
+	}
+	if *customMetadata {
+		opts = append(opts, vault.WithCustomMetadata())
+	}
+	policy, err := vault.GeneratePolicy(access, opts...)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(out, policy)
+	return err
+}
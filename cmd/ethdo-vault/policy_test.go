@@ -0,0 +1,70 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		access vault.PolicyAccess
+		opts   []vault.Option
+		err    string
+	}{
+		{
+			name:   "Default",
+			args:   []string{"--mount", "secret"},
+			access: vault.PolicyReadWriteDelete,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("secret"), vault.WithVaultKVVersion(2)},
+		},
+		{
+			name:   "ReadOnly",
+			args:   []string{"--mount", "kv", "--kv-version", "1", "--read-only"},
+			access: vault.PolicyReadOnly,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("kv"), vault.WithVaultKVVersion(1)},
+		},
+		{
+			name:   "NoDeleteCustomMetadata",
+			args:   []string{"--mount", "secret", "--no-delete", "--custom-metadata"},
+			access: vault.PolicyReadWrite,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("secret"), vault.WithVaultKVVersion(2), vault.WithCustomMetadata()},
+		},
+		{
+			name: "MountMissing",
+			err:  "mount",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := run(append([]string{"policy"}, test.args...)...)
+			if test.err != "" {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.Nil(t, err)
+			policy, err := vault.GeneratePolicy(test.access, test.opts...)
+			require.Nil(t, err)
+			assert.Equal(t, policy, out)
+		})
+	}
+}
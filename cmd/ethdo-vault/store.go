@@ -0,0 +1,142 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+	indexer "github.com/wealdtech/go-indexer"
+)
+
+// secretFields are the fields of wallet and account data that hold secrets, and so are never shown.
+var secretFields = []string{"crypto"}
+
+// storeFlags adds the flags used to connect to the store to a flag set.
+func storeFlags(flags *flag.FlagSet) *string {
+	return flags.String("config", "", "path to a store configuration file; if not supplied the store is configured from the environment")
+}
+
+// openStore opens the store.
+func openStore(configPath string) (*vault.Store, error) {
+	var store wtypes.Store
+	var err error
+	if configPath != "" {
+		store, err = vault.NewFromConfig(configPath)
+	} else {
+		store, err = vault.NewFromEnv()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store.(*vault.Store), nil
+}
+
+// info is the identifying information of a wallet or account.
+type info struct {
+	ID     uuid.UUID `json:"uuid"`
+	Name   string    `json:"name"`
+	PubKey string    `json:"pubkey,omitempty"`
+}
+
+// parseInfo parses the identifying information from wallet or account data.
+func parseInfo(data []byte) (*info, error) {
+	res := &info{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+	if res.ID == uuid.Nil {
+		return nil, errors.New("uuid missing")
+	}
+	if res.Name == "" {
+		return nil, errors.New("name missing")
+	}
+	return res, nil
+}
+
+// findWallet finds a wallet given its name or ID.
+func findWallet(store *vault.Store, wallet string) (*info, error) {
+	if wallet == "" {
+		return nil, errors.New("--wallet is required")
+	}
+	var data []byte
+	var err error
+	if walletID, parseErr := uuid.Parse(wallet); parseErr == nil {
+		data, err = store.RetrieveWalletByID(walletID)
+	} else {
+		data, err = store.RetrieveWallet(wallet)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(data)
+}
+
+// findAccount finds an account in a wallet given its name or ID, returning its data.
+func findAccount(store *vault.Store, walletID uuid.UUID, account string) ([]byte, error) {
+	if account == "" {
+		return nil, errors.New("--account is required")
+	}
+	if accountID, err := uuid.Parse(account); err == nil {
+		return store.RetrieveAccount(walletID, accountID)
+	}
+	for data := range store.RetrieveAccounts(walletID) {
+		accountInfo, err := parseInfo(data)
+		if err == nil && accountInfo.Name == account {
+			return data, nil
+		}
+	}
+	return nil, errors.New("account not found")
+}
+
+// updateIndex applies a change to the accounts index of a wallet.
+func updateIndex(store *vault.Store, walletID uuid.UUID, update func(*indexer.Index)) error {
+	index := indexer.New()
+	data, err := store.RetrieveAccountsIndex(walletID)
+	if err == nil {
+		index, err = indexer.Deserialize(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse accounts index: %v", err)
+		}
+	}
+	update(index)
+	data, err = index.Serialize()
+	if err != nil {
+		return err
+	}
+	return store.StoreAccountsIndex(walletID, data)
+}
+
+// writeMetadata writes wallet or account data without its secret fields.
+func writeMetadata(out io.Writer, data []byte) error {
+	metadata := make(map[string]interface{})
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return err
+	}
+	for _, field := range secretFields {
+		delete(metadata, field)
+	}
+	res, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(res))
+	return err
+}
@@ -0,0 +1,59 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+)
+
+// runVerify classifies every secret in the store, listing those the store cannot read.
+func runVerify(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := store.Verify(context.Background())
+	if err != nil {
+		return err
+	}
+	statuses := make([]string, 0, len(report.Counts))
+	for status := range report.Counts {
+		statuses = append(statuses, string(status))
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(out, "%s\t%d\n", status, report.Counts[vault.SecretStatus(status)])
+	}
+	issues := report.Issues()
+	for _, issue := range issues {
+		fmt.Fprintf(out, "%s\t%s\n", issue.Path, issue.Status)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("%d of %d secrets are not %s", len(issues), len(report.Secrets), report.Expected)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	mount := setStoreEnv(t)
+	walletID := uuid.New()
+	_, err := run("wallet", "store", "--file", writeFile(t, "wallet.json", fmt.Sprintf(`{"uuid":%q,"name":"cli wallet"}`, walletID)))
+	require.Nil(t, err)
+
+	out, err := run("verify")
+	require.Nil(t, err)
+	assert.Contains(t, out, "decryptable\t")
+
+	// A wallet written with a different passphrase cannot be read.
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("other")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath(mount),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+	otherWalletID := uuid.New()
+	require.Nil(t, store.StoreWallet(otherWalletID, "other wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"other wallet"}`, otherWalletID))))
+
+	out, err = run("verify")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "secrets are not decryptable")
+	assert.Contains(t, out, "wrong key")
+}
@@ -0,0 +1,161 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// listPageSize is the number of wallets or accounts retrieved from the store at a time when listing.
+const listPageSize = 100
+
+var walletCommands = map[string]*command{
+	"list": {
+		description: "list wallets",
+		run:         runWalletList,
+	},
+	"info": {
+		description: "show wallet metadata",
+		run:         runWalletInfo,
+	},
+	"store": {
+		description: "store a wallet from a JSON file",
+		run:         runWalletStore,
+	},
+	"delete": {
+		description: "delete a wallet that has no accounts",
+		run:         runWalletDelete,
+	},
+}
+
+// runWalletList lists wallets.
+func runWalletList(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("wallet list", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	cursor := ""
+	for {
+		page, err := store.ListWallets(context.Background(), cursor, listPageSize)
+		if err != nil {
+			return err
+		}
+		for _, data := range page.Items {
+			walletInfo, err := parseInfo(data)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(out, "%s\t%s\n", walletInfo.ID, walletInfo.Name)
+		}
+		if page.Cursor == "" {
+			return nil
+		}
+		cursor = page.Cursor
+	}
+}
+
+// runWalletInfo shows wallet metadata.
+func runWalletInfo(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("wallet info", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	wallet := flags.String("wallet", "", "name or ID of the wallet")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	walletInfo, err := findWallet(store, *wallet)
+	if err != nil {
+		return err
+	}
+	data, err := store.RetrieveWalletByID(walletInfo.ID)
+	if err != nil {
+		return err
+	}
+	return writeMetadata(out, data)
+}
+
+// runWalletStore stores a wallet from a JSON file.
+func runWalletStore(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("wallet store", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	file := flags.String("file", "", "path to the wallet JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("--file is required")
+	}
+	// #nosec G304
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	walletInfo, err := parseInfo(data)
+	if err != nil {
+		return fmt.Errorf("invalid wallet: %v", err)
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// The store does not prevent multiple wallets with the same name, so check here.
+	if existing, err := findWallet(store, walletInfo.Name); err == nil && existing.ID != walletInfo.ID {
+		return fmt.Errorf("wallet %q already exists with ID %s", walletInfo.Name, existing.ID)
+	}
+	if err := store.StoreWallet(walletInfo.ID, walletInfo.Name, data); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s\t%s\n", walletInfo.ID, walletInfo.Name)
+	return nil
+}
+
+// runWalletDelete deletes a wallet.
+func runWalletDelete(args []string, _ io.Writer) error {
+	flags := flag.NewFlagSet("wallet delete", flag.ContinueOnError)
+	configPath := storeFlags(flags)
+	wallet := flags.String("wallet", "", "name or ID of the wallet")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	walletInfo, err := findWallet(store, *wallet)
+	if err != nil {
+		return err
+	}
+	return store.DeleteWallet(walletInfo.ID)
+}
@@ -0,0 +1,54 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWallet(t *testing.T) {
+	setStoreEnv(t)
+	walletID := uuid.New()
+	file := writeFile(t, "wallet.json", fmt.Sprintf(`{"uuid":%q,"name":"cli wallet","type":"non-deterministic","crypto":{"secret":"value"}}`, walletID))
+
+	out, err := run("wallet", "store", "--file", file)
+	require.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("%s\tcli wallet\n", walletID), out)
+
+	// A different wallet with the same name is refused.
+	file = writeFile(t, "other.json", fmt.Sprintf(`{"uuid":%q,"name":"cli wallet"}`, uuid.New()))
+	_, err = run("wallet", "store", "--file", file)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+
+	out, err = run("wallet", "list")
+	require.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("%s\tcli wallet\n", walletID), out)
+
+	out, err = run("wallet", "info", "--wallet", "cli wallet")
+	require.Nil(t, err)
+	assert.Contains(t, out, walletID.String())
+	assert.NotContains(t, out, "secret")
+
+	_, err = run("wallet", "delete", "--wallet", walletID.String())
+	require.Nil(t, err)
+	out, err = run("wallet", "list")
+	require.Nil(t, err)
+	assert.Empty(t, out)
+}
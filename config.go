@@ -0,0 +1,306 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the configuration for the store as held in a configuration file.
+// Keys are the same as the option names.
+type fileConfig struct {
+	ID                      string   `yaml:"id"`
+	Passphrase              string   `yaml:"passphrase"`
+	VaultAddr               string   `yaml:"vault_addr"`
+	VaultNamespace          string   `yaml:"vault_namespace"`
+	VaultCACert             string   `yaml:"vault_cacert"`
+	VaultReadAddrs          []string `yaml:"vault_read_addrs"`
+	VaultAuth               string   `yaml:"vault_auth"`
+	VaultToken              string   `yaml:"vault_token"`
+	VaultK8sAuthRole        string   `yaml:"vault_k8s_auth_role"`
+	VaultK8sAuthSATokenPath string   `yaml:"vault_k8s_auth_sa_token_path"`
+	VaultK8sAuthMountPath   string   `yaml:"vault_k8s_auth_mount_path"`
+	VaultSecretsMountPath   string   `yaml:"vault_secrets_mount_path"`
+	VaultKVVersion          int      `yaml:"vault_kv_version"`
+	AccountLayout           string   `yaml:"account_layout"`
+	CustomMetadata          bool     `yaml:"custom_metadata"`
+	RequireBinding          bool     `yaml:"require_binding"`
+	StrictRetrieval         bool     `yaml:"strict_retrieval"`
+	LockMemory              bool     `yaml:"lock_memory"`
+	RedactLogs              bool     `yaml:"redact_logs"`
+	AuditFile               string   `yaml:"audit_file"`
+	ReadOnly                bool     `yaml:"read_only"`
+}
+
+// NewFromEnv creates a new Vault store configured from environment variables.
+// The standard Vault variables VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE and VAULT_CACERT are honoured,
+// along with the following store-specific variables:
+//   - VAULT_STORE_ID: the ID for the store
+//   - VAULT_STORE_READ_ADDRS: comma-separated addresses of Vault performance standbys or replicas from which to read
+//   - VAULT_STORE_PASSPHRASE: the passphrase for the store
+//   - VAULT_STORE_AUTH: the authentication type, defaults to "token" if VAULT_TOKEN is set
+//   - VAULT_STORE_K8S_AUTH_ROLE: the Kubernetes auth role
+//   - VAULT_STORE_K8S_AUTH_SA_TOKEN_PATH: the path to the Kubernetes service account token
+//   - VAULT_STORE_K8S_AUTH_MOUNT_PATH: the Kubernetes auth module path
+//   - VAULT_STORE_SECRETS_MOUNT_PATH: the KV secrets module path
+//   - VAULT_STORE_KV_VERSION: the KV secrets module version
+//   - VAULT_STORE_ACCOUNT_LAYOUT: the layout of accounts within their wallet, "flat" or "sharded"
+//   - VAULT_STORE_CUSTOM_METADATA: "true" to write non-secret attributes to KV version 2 custom metadata
+//   - VAULT_STORE_REQUIRE_BINDING: "true" to refuse encrypted secrets not bound to their location
+//   - VAULT_STORE_STRICT_RETRIEVAL: "true" to fail rather than skip wallets and accounts that cannot be read
+//   - VAULT_STORE_LOCK_MEMORY: "true" to lock the passphrase in to memory
+//   - VAULT_STORE_REDACT_LOGS: "true" to remove wallet and account IDs, and the paths containing them, from logs
+//   - VAULT_STORE_AUDIT_FILE: the file to which audit events are appended
+//   - VAULT_STORE_READ_ONLY: "true" to make the store read-only
+//
+// Options passed explicitly take precedence over environment variables.
+func NewFromEnv(opts ...Option) (wtypes.Store, error) {
+	envOpts, err := optionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return New(append(envOpts, opts...)...)
+}
+
+// NewFromConfig creates a new Vault store configured from a YAML or JSON file.
+// Keys in the file are the option names, for example vault_addr and vault_secrets_mount_path.
+//
+// Environment variables, as described in NewFromEnv, take precedence over values in the file,
+// and options passed explicitly take precedence over both.
+func NewFromConfig(path string, opts ...Option) (wtypes.Store, error) {
+	fileOpts, err := optionsFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	envOpts, err := optionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return New(append(append(fileOpts, envOpts...), opts...)...)
+}
+
+// optionsFromFile obtains options from a YAML or JSON configuration file.
+func optionsFromFile(path string) ([]Option, error) {
+	// #nosec G304
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read configuration file")
+	}
+
+	config := &fileConfig{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		return nil, errors.Wrap(err, "failed to parse configuration file")
+	}
+
+	opts := make([]Option, 0)
+	if config.ID != "" {
+		opts = append(opts, WithID([]byte(config.ID)))
+	}
+	if config.Passphrase != "" {
+		opts = append(opts, WithPassphrase([]byte(config.Passphrase)))
+	}
+	if config.VaultAddr != "" {
+		opts = append(opts, WithVaultAddr(config.VaultAddr))
+	}
+	if config.VaultNamespace != "" {
+		opts = append(opts, WithVaultNamespace(config.VaultNamespace))
+	}
+	if config.VaultCACert != "" {
+		opts = append(opts, WithVaultCACert(config.VaultCACert))
+	}
+	if len(config.VaultReadAddrs) > 0 {
+		opts = append(opts, WithVaultReadAddrs(config.VaultReadAddrs))
+	}
+	if config.VaultAuth != "" {
+		opts = append(opts, WithVaultAuth(config.VaultAuth))
+	}
+	if config.VaultToken != "" {
+		opts = append(opts, WithVaultToken(config.VaultToken))
+	}
+	if config.VaultK8sAuthRole != "" {
+		opts = append(opts, WithVaultKubernetesAuthRole(config.VaultK8sAuthRole))
+	}
+	if config.VaultK8sAuthSATokenPath != "" {
+		opts = append(opts, WithVaultKubernetesAuthSATokenPath(config.VaultK8sAuthSATokenPath))
+	}
+	if config.VaultK8sAuthMountPath != "" {
+		opts = append(opts, WithVaultKubernetesAuth(config.VaultK8sAuthMountPath))
+	}
+	if config.VaultSecretsMountPath != "" {
+		opts = append(opts, WithVaultSecretMountPath(config.VaultSecretsMountPath))
+	}
+	if config.VaultKVVersion != 0 {
+		opts = append(opts, WithVaultKVVersion(config.VaultKVVersion))
+	}
+	if config.AccountLayout != "" {
+		opts = append(opts, WithAccountLayout(config.AccountLayout))
+	}
+	if config.CustomMetadata {
+		opts = append(opts, WithCustomMetadata())
+	}
+	if config.RequireBinding {
+		opts = append(opts, WithRequireBinding())
+	}
+	if config.StrictRetrieval {
+		opts = append(opts, WithStrictRetrieval())
+	}
+	if config.LockMemory {
+		opts = append(opts, WithLockedMemory())
+	}
+	if config.RedactLogs {
+		opts = append(opts, WithLogRedaction())
+	}
+	if config.AuditFile != "" {
+		opts = append(opts, WithAuditFile(config.AuditFile))
+	}
+	if config.ReadOnly {
+		opts = append(opts, WithReadOnly())
+	}
+
+	return opts, nil
+}
+
+// optionsFromEnv obtains options from environment variables.
+func optionsFromEnv() ([]Option, error) {
+	opts := make([]Option, 0)
+	if val, exists := os.LookupEnv("VAULT_STORE_ID"); exists {
+		opts = append(opts, WithID([]byte(val)))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_PASSPHRASE"); exists {
+		opts = append(opts, WithPassphrase([]byte(val)))
+	}
+	if val, exists := os.LookupEnv("VAULT_ADDR"); exists {
+		opts = append(opts, WithVaultAddr(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_NAMESPACE"); exists {
+		opts = append(opts, WithVaultNamespace(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_CACERT"); exists {
+		opts = append(opts, WithVaultCACert(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_READ_ADDRS"); exists {
+		addrs := make([]string, 0)
+		for _, addr := range strings.Split(val, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+		opts = append(opts, WithVaultReadAddrs(addrs))
+	}
+	if val, exists := os.LookupEnv("VAULT_TOKEN"); exists {
+		opts = append(opts, WithVaultToken(val))
+		// Default to token authentication if a token is supplied but no authentication type is configured.
+		opts = append(opts, optionFunc(func(o *options) {
+			if o.vault_auth == "" {
+				o.vault_auth = "token"
+			}
+		}))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_AUTH"); exists {
+		opts = append(opts, WithVaultAuth(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_K8S_AUTH_ROLE"); exists {
+		opts = append(opts, WithVaultKubernetesAuthRole(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_K8S_AUTH_SA_TOKEN_PATH"); exists {
+		opts = append(opts, WithVaultKubernetesAuthSATokenPath(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_K8S_AUTH_MOUNT_PATH"); exists {
+		opts = append(opts, WithVaultKubernetesAuth(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_SECRETS_MOUNT_PATH"); exists {
+		opts = append(opts, WithVaultSecretMountPath(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_KV_VERSION"); exists {
+		version, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAULT_STORE_KV_VERSION %q", val)
+		}
+		opts = append(opts, WithVaultKVVersion(version))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_ACCOUNT_LAYOUT"); exists {
+		opts = append(opts, WithAccountLayout(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_CUSTOM_METADATA"); exists {
+		customMetadata, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAULT_STORE_CUSTOM_METADATA %q", val)
+		}
+		// Boolean values are set whether true or false, so that false overrides the configuration file.
+		opts = append(opts, optionFunc(func(o *options) {
+			o.custom_metadata = customMetadata
+		}))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_REQUIRE_BINDING"); exists {
+		requireBinding, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAULT_STORE_REQUIRE_BINDING %q", val)
+		}
+		opts = append(opts, optionFunc(func(o *options) {
+			o.require_binding = requireBinding
+		}))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_STRICT_RETRIEVAL"); exists {
+		strictRetrieval, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAULT_STORE_STRICT_RETRIEVAL %q", val)
+		}
+		opts = append(opts, optionFunc(func(o *options) {
+			o.strict_retrieval = strictRetrieval
+		}))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_LOCK_MEMORY"); exists {
+		lockMemory, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAULT_STORE_LOCK_MEMORY %q", val)
+		}
+		opts = append(opts, optionFunc(func(o *options) {
+			o.lock_memory = lockMemory
+		}))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_REDACT_LOGS"); exists {
+		redactLogs, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAULT_STORE_REDACT_LOGS %q", val)
+		}
+		opts = append(opts, optionFunc(func(o *options) {
+			o.redact_logs = redactLogs
+		}))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_AUDIT_FILE"); exists {
+		opts = append(opts, WithAuditFile(val))
+	}
+	if val, exists := os.LookupEnv("VAULT_STORE_READ_ONLY"); exists {
+		readOnly, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAULT_STORE_READ_ONLY %q", val)
+		}
+		opts = append(opts, optionFunc(func(o *options) {
+			o.read_only = readOnly
+		}))
+	}
+
+	return opts, nil
+}
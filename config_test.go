@@ -0,0 +1,186 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unsetEnv clears any store-related environment variables for the duration of the test.
+func unsetEnv(t *testing.T) {
+	for _, name := range []string{
+		"VAULT_ADDR",
+		"VAULT_TOKEN",
+		"VAULT_NAMESPACE",
+		"VAULT_CACERT",
+		"VAULT_STORE_READ_ADDRS",
+		"VAULT_STORE_ID",
+		"VAULT_STORE_PASSPHRASE",
+		"VAULT_STORE_AUTH",
+		"VAULT_STORE_K8S_AUTH_ROLE",
+		"VAULT_STORE_K8S_AUTH_SA_TOKEN_PATH",
+		"VAULT_STORE_K8S_AUTH_MOUNT_PATH",
+		"VAULT_STORE_SECRETS_MOUNT_PATH",
+		"VAULT_STORE_KV_VERSION",
+		"VAULT_STORE_ACCOUNT_LAYOUT",
+		"VAULT_STORE_CUSTOM_METADATA",
+		"VAULT_STORE_REQUIRE_BINDING",
+		"VAULT_STORE_STRICT_RETRIEVAL",
+		"VAULT_STORE_LOCK_MEMORY",
+		"VAULT_STORE_REDACT_LOGS",
+		"VAULT_STORE_AUDIT_FILE",
+		"VAULT_STORE_READ_ONLY",
+	} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv("VAULT_ADDR", "http://localhost:8200")
+	t.Setenv("VAULT_TOKEN", "golang-test")
+	t.Setenv("VAULT_STORE_SECRETS_MOUNT_PATH", "secret")
+	t.Setenv("VAULT_STORE_PASSPHRASE", "test")
+
+	store, err := vault.NewFromEnv()
+	require.Nil(t, err)
+	assert.Equal(t, "vault", store.Name())
+}
+
+func TestNewFromEnvBadKVVersion(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv("VAULT_ADDR", "http://localhost:8200")
+	t.Setenv("VAULT_TOKEN", "golang-test")
+	t.Setenv("VAULT_STORE_SECRETS_MOUNT_PATH", "secret")
+	t.Setenv("VAULT_STORE_KV_VERSION", "two")
+
+	_, err := vault.NewFromEnv()
+	assert.NotNil(t, err)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	unsetEnv(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+		err    bool
+	}{
+		{
+			name: "YAML",
+			config: `vault_addr: http://localhost:8200
+vault_auth: token
+vault_token: golang-test
+vault_secrets_mount_path: secret
+passphrase: test
+`,
+		},
+		{
+			name:   "JSON",
+			config: `{"vault_addr":"http://localhost:8200","vault_auth":"token","vault_token":"golang-test","vault_secrets_mount_path":"secret"}`,
+		},
+		{
+			name: "UnknownKey",
+			config: `vault_addr: http://localhost:8200
+vault_secret_mount_path: secret
+`,
+			err: true,
+		},
+		{
+			name: "MissingMount",
+			config: `vault_addr: http://localhost:8200
+vault_auth: token
+vault_token: golang-test
+`,
+			err: true,
+		},
+		{
+			name: "EnvOverride",
+			config: `vault_addr: http://localhost:8200
+vault_auth: token
+vault_token: bad-token
+vault_secrets_mount_path: secret
+`,
+			env: map[string]string{
+				"VAULT_TOKEN": "golang-test",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			path := filepath.Join(dir, test.name)
+			require.Nil(t, ioutil.WriteFile(path, []byte(test.config), 0600))
+			store, err := vault.NewFromConfig(path)
+			if test.err {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+				assert.Equal(t, "vault", store.Name())
+			}
+		})
+	}
+}
+
+func TestNewFromConfigOptionOverride(t *testing.T) {
+	unsetEnv(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`vault_addr: http://localhost:8200
+vault_auth: token
+vault_token: golang-test
+vault_secrets_mount_path: missing
+`), 0600))
+
+	store, err := vault.NewFromConfig(path, vault.WithVaultSecretMountPath("secret"))
+	require.Nil(t, err)
+	assert.Equal(t, "vault", store.Name())
+}
+
+func TestNewFromConfigEnvFalseOverride(t *testing.T) {
+	unsetEnv(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`vault_addr: http://localhost:8200
+vault_auth: token
+vault_token: golang-test
+vault_secrets_mount_path: secret
+passphrase: test
+read_only: true
+`), 0600))
+
+	store, err := vault.NewFromConfig(path)
+	require.Nil(t, err)
+	walletID := uuid.New()
+	walletData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"config wallet %s"}`, walletID, walletID))
+	require.NotNil(t, store.StoreWallet(walletID, fmt.Sprintf("config wallet %s", walletID), walletData))
+
+	t.Setenv("VAULT_STORE_READ_ONLY", "false")
+	store, err = vault.NewFromConfig(path)
+	require.Nil(t, err)
+	require.Nil(t, store.StoreWallet(walletID, fmt.Sprintf("config wallet %s", walletID), walletData))
+}
@@ -14,36 +14,162 @@
 package vaultstorage
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/wealdtech/go-ecodec"
+	"golang.org/x/crypto/pbkdf2"
 )
 
-// encryptIfRequired encrypts data if required.
-func (s *Store) encryptIfRequired(data []byte) ([]byte, error) {
-	if len(data) == 0 {
-		return data, nil
+// boundMagic prefixes data encrypted with its binding as associated data.
+// Data without it was encrypted by ecodec, which starts with a version byte of 1.
+var boundMagic = []byte("WSV1")
+
+// errUnbound is the error for encrypted data that is not bound to its location, when the store requires binding.
+var errUnbound = errors.New("secret is not bound to its location")
+
+const (
+	boundSaltLen  = 32
+	boundNonceLen = 12
+	// boundPBKDF2c matches the work factor of ecodec.
+	boundPBKDF2c = 262144
+)
+
+// Kinds of secret.
+const (
+	secretKindWallet         = "wallet"
+	secretKindAccount        = "account"
+	secretKindIndex          = "index"
+	secretKindPublicKeyIndex = "pubkeys"
+)
+
+// secretBinding identifies the wallet, account or index held in a secret.
+// It is bound to the secret's ciphertext, so ciphertext copied to another secret fails to decrypt.
+type secretBinding struct {
+	kind      string
+	walletID  uuid.UUID
+	accountID uuid.UUID
+}
+
+func walletBinding(walletID uuid.UUID) *secretBinding {
+	return &secretBinding{kind: secretKindWallet, walletID: walletID}
+}
+
+func accountBinding(walletID uuid.UUID, accountID uuid.UUID) *secretBinding {
+	return &secretBinding{kind: secretKindAccount, walletID: walletID, accountID: accountID}
+}
+
+func indexBinding(walletID uuid.UUID) *secretBinding {
+	return &secretBinding{kind: secretKindIndex, walletID: walletID}
+}
+
+func publicKeyIndexBinding() *secretBinding {
+	return &secretBinding{kind: secretKindPublicKeyIndex}
+}
+
+// String describes the secret.
+func (b *secretBinding) String() string {
+	switch b.kind {
+	case secretKindWallet:
+		return fmt.Sprintf("wallet %s", b.walletID)
+	case secretKindAccount:
+		return fmt.Sprintf("account %s in wallet %s", b.accountID, b.walletID)
+	case secretKindIndex:
+		return fmt.Sprintf("index of wallet %s", b.walletID)
+	default:
+		return "public key index"
 	}
-	if len(data) < 16 {
-		return nil, errors.New("data must be at least 16 bytes")
+}
+
+// associatedData returns the associated data binding a secret to its store and location.
+func (s *Store) associatedData(binding *secretBinding) []byte {
+	return []byte(fmt.Sprintf("go-eth2-wallet-store-vault\x00%x\x00%s\x00%s\x00%s", s.id, binding.kind, binding.walletID, binding.accountID))
+}
+
+// encryptBound encrypts data with the store passphrase, binding it to its location.
+func (s *Store) encryptBound(data []byte, binding *secretBinding) ([]byte, error) {
+	salt := make([]byte, boundSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, boundNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
 	}
-	var err error
-	if len(s.passphrase) > 0 {
-		data, err = ecodec.Encrypt(data, s.passphrase)
+	aead, err := s.boundCipher(salt, boundPBKDF2c)
+	if err != nil {
+		return nil, err
 	}
-	return data, err
+
+	res := make([]byte, 0, len(boundMagic)+boundSaltLen+boundNonceLen+len(data)+aead.Overhead())
+	res = append(res, boundMagic...)
+	res = append(res, salt...)
+	res = append(res, nonce...)
+	return aead.Seal(res, nonce, data, s.associatedData(binding)), nil
 }
 
-// decryptIfRequired decrypts data if required.
-func (s *Store) decryptIfRequired(data []byte) ([]byte, error) {
+// decryptBound decrypts data encrypted by encryptBound, checking that it is bound to its location.
+func (s *Store) decryptBound(data []byte, binding *secretBinding, iterations int) ([]byte, error) {
+	if !bytes.HasPrefix(data, boundMagic) || len(data) < len(boundMagic)+boundSaltLen+boundNonceLen {
+		return nil, errors.New("encrypted data is malformed")
+	}
+	salt := data[len(boundMagic) : len(boundMagic)+boundSaltLen]
+	nonce := data[len(boundMagic)+boundSaltLen : len(boundMagic)+boundSaltLen+boundNonceLen]
+	aead, err := s.boundCipher(salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	// Decrypt in place to avoid a further copy of the plaintext.
+	ciphertext := data[len(boundMagic)+boundSaltLen+boundNonceLen:]
+	res, err := aead.Open(ciphertext[:0], nonce, ciphertext, s.associatedData(binding))
+	if err != nil {
+		// Either the passphrase is wrong or the secret has been moved from another location.
+		return nil, errors.New("invalid key or secret not bound to its location")
+	}
+	return res, nil
+}
+
+// decryptLegacy decrypts data held without an envelope, if the store has a passphrase.
+// Data encrypted before secrets were bound to their location is accepted, with a warning, unless the store requires
+// binding.
+func (s *Store) decryptLegacy(data []byte, binding *secretBinding) ([]byte, error) {
 	if len(data) == 0 {
 		return data, nil
 	}
 	if len(data) < 16 {
 		return nil, errors.New("data must be at least 16 bytes")
 	}
-	var err error
-	if len(s.passphrase) > 0 {
-		data, err = ecodec.Decrypt(data, s.passphrase)
+	if len(s.passphrase) == 0 {
+		return data, nil
+	}
+
+	if !bytes.HasPrefix(data, boundMagic) {
+		if s.require_binding {
+			return nil, errUnbound
+		}
+		res, err := ecodec.Decrypt(data, s.passphrase)
+		if err != nil {
+			return nil, err
+		}
+		s.logger.Warn("read secret that is not bound to its location; run UpgradeEnvelopes to bind it", secretFields(binding, nil)...)
+		return res, nil
+	}
+	return s.decryptBound(data, binding, boundPBKDF2c)
+}
+
+// boundCipher returns the AES-GCM cipher for the given salt and PBKDF2 iterations.
+func (s *Store) boundCipher(salt []byte, iterations int) (cipher.AEAD, error) {
+	key := pbkdf2.Key(s.passphrase, salt, iterations, 32, sha256.New)
+	defer zero(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
 	}
-	return data, err
+	return cipher.NewGCM(block)
 }
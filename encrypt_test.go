@@ -14,15 +14,19 @@
 package vaultstorage_test
 
 import (
+	"context"
+	b64 "encoding/base64"
 	"fmt"
 	"math/rand"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	vaultapi "github.com/hashicorp/vault/api"
 	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/wealdtech/go-ecodec"
 )
 
 func TestStoreRetrieveEncryptedWallet(t *testing.T) {
@@ -138,3 +142,63 @@ func TestBadWalletKey(t *testing.T) {
 	_, err = store.RetrieveWallet(walletName)
 	require.NotNil(t, err)
 }
+
+func TestSwappedAccount(t *testing.T) {
+	ctx := context.Background()
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	newStore := func(opts ...vault.Option) *vault.Store {
+		store, err := vault.New(append([]vault.Option{
+			vault.WithPassphrase([]byte("test")),
+			vault.WithVaultAddr("http://localhost:8200"),
+			vault.WithVaultSecretMountPath(mount),
+			vault.WithVaultToken("golang-test"),
+			vault.WithVaultAuth("token"),
+		}, opts...)...)
+		require.Nil(t, err)
+		return store.(*vault.Store)
+	}
+	store := newStore()
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "swap wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"swap wallet"}`, walletID))))
+	accountID1 := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID1, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account 1"}`, accountID1))))
+	accountID2 := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID2, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account 2"}`, accountID2))))
+
+	// A store with a different ID cannot read the account.
+	_, err := newStore(vault.WithID([]byte("other"))).RetrieveAccount(walletID, accountID1)
+	require.NotNil(t, err)
+
+	// Copy the ciphertext of the first account over the second.
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken("golang-test")
+	kv := client.KVv1(mount)
+	secret, err := kv.Get(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID1))
+	require.Nil(t, err)
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID2), secret.Data))
+
+	_, err = store.RetrieveAccount(walletID, accountID2)
+	require.NotNil(t, err)
+	assert.Equal(t, "invalid key or secret not bound to its location", err.Error())
+	assert.Len(t, drain(store.RetrieveAccounts(walletID)), 1)
+
+	// Secrets encrypted before binding are read unless binding is required.
+	legacyID := uuid.New()
+	legacyData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"legacy account"}`, legacyID))
+	encrypted, err := ecodec.Encrypt(legacyData, []byte("test"))
+	require.Nil(t, err)
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, legacyID), map[string]interface{}{
+		"data": b64.URLEncoding.EncodeToString(encrypted),
+	}))
+	data, err := store.RetrieveAccount(walletID, legacyID)
+	require.Nil(t, err)
+	assert.Equal(t, legacyData, data)
+	_, err = newStore(vault.WithRequireBinding()).RetrieveAccount(walletID, legacyID)
+	require.NotNil(t, err)
+	assert.Equal(t, "secret is not bound to its location", err.Error())
+}
@@ -0,0 +1,383 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// envelopeVersion is the version of the envelope in which secrets are written.
+// Secrets written before envelopes were introduced hold only a data field, and are treated as version 0.
+const envelopeVersion = 1
+
+// Encryption schemes of enveloped secrets.
+const (
+	// schemeNone holds data unencrypted.
+	schemeNone = "none"
+	// schemeAESGCM holds data encrypted with AES-256-GCM, bound to its location, under a key derived with PBKDF2.
+	schemeAESGCM = "aes-256-gcm"
+)
+
+// kdfPBKDF2 is the key derivation function of encrypted secrets.
+const kdfPBKDF2 = "pbkdf2-sha256"
+
+// kdfPBKDF2Iterations are the PBKDF2 iteration counts with which the store has encrypted secrets.  Others are rejected
+// before deriving a key, as the count is read from the secret and an unbounded one would make reads hang.
+var kdfPBKDF2Iterations = map[int]bool{
+	boundPBKDF2c: true,
+}
+
+// envelope is a secret as held in Vault.
+type envelope struct {
+	version       int
+	scheme        string
+	kdf           string
+	kdfIterations int
+	checksum      string
+	data          []byte
+}
+
+// unencrypted returns true if data is held unencrypted regardless of the passphrase.
+// Empty indices are not encrypted, as encryption requires at least 16 bytes.
+func unencrypted(data []byte, binding *secretBinding) bool {
+	return len(data) == 0 ||
+		(len(data) == 2 && (binding.kind == secretKindIndex || binding.kind == secretKindPublicKeyIndex))
+}
+
+// sealSecret encrypts data if required and returns it in an envelope, ready to be written to Vault.
+func (s *Store) sealSecret(data []byte, binding *secretBinding) (map[string]interface{}, error) {
+	s.keyMu.RLock()
+	defer s.keyMu.RUnlock()
+	if s.closed {
+		return nil, errClosed
+	}
+
+	if !unencrypted(data, binding) && len(data) < 16 {
+		return nil, errors.New("data must be at least 16 bytes")
+	}
+	if unencrypted(data, binding) || len(s.passphrase) == 0 {
+		return envelopeSecret(&envelope{
+			version: envelopeVersion,
+			scheme:  schemeNone,
+			data:    data,
+		}), nil
+	}
+
+	encData, err := s.encryptBound(data, binding)
+	if err != nil {
+		return nil, err
+	}
+	return envelopeSecret(&envelope{
+		version:       envelopeVersion,
+		scheme:        schemeAESGCM,
+		kdf:           kdfPBKDF2,
+		kdfIterations: boundPBKDF2c,
+		data:          encData,
+	}), nil
+}
+
+// openSecret returns the data of a secret as read from Vault, decrypting it if required.
+func (s *Store) openSecret(secret map[string]interface{}, binding *secretBinding) ([]byte, error) {
+	env, err := parseEnvelope(secret)
+	if err != nil {
+		return nil, &decodeError{err: err}
+	}
+	data, err := s.openEnvelope(env, binding)
+	if err != nil && err != errClosed {
+		return nil, &decodeError{err: err}
+	}
+	return data, err
+}
+
+// openEnvelope returns the data of an envelope, decrypting it if required.
+// Encrypted data is decrypted in place, so the envelope's data is not valid afterwards.
+func (s *Store) openEnvelope(env *envelope, binding *secretBinding) ([]byte, error) {
+	s.keyMu.RLock()
+	defer s.keyMu.RUnlock()
+	if s.closed {
+		return nil, errClosed
+	}
+
+	if env.version == 0 {
+		if unencrypted(env.data, binding) {
+			return env.data, nil
+		}
+		return s.decryptLegacy(env.data, binding)
+	}
+
+	if env.checksum != checksum(env.data) {
+		return nil, errors.New("secret checksum mismatch")
+	}
+	switch env.scheme {
+	case schemeNone:
+		if len(s.passphrase) > 0 && !unencrypted(env.data, binding) {
+			return nil, errors.New("secret is not encrypted")
+		}
+		return env.data, nil
+	case schemeAESGCM:
+		if len(s.passphrase) == 0 {
+			return nil, errors.New("secret is encrypted but the store has no passphrase")
+		}
+		if env.kdf != kdfPBKDF2 {
+			return nil, fmt.Errorf("unsupported key derivation function %q", env.kdf)
+		}
+		if !kdfPBKDF2Iterations[env.kdfIterations] {
+			return nil, fmt.Errorf("unsupported key derivation iterations %d", env.kdfIterations)
+		}
+		return s.decryptBound(env.data, binding, env.kdfIterations)
+	default:
+		return nil, fmt.Errorf("unsupported encryption scheme %q", env.scheme)
+	}
+}
+
+// current returns true if the envelope is as the store would write it now.
+// It must be called before the envelope is opened.
+func (s *Store) current(env *envelope, binding *secretBinding) bool {
+	if env.version != envelopeVersion {
+		return false
+	}
+	if len(s.passphrase) == 0 {
+		return env.scheme == schemeNone
+	}
+	if env.scheme == schemeNone {
+		return unencrypted(env.data, binding)
+	}
+	return env.scheme == schemeAESGCM && env.kdf == kdfPBKDF2 && env.kdfIterations == boundPBKDF2c
+}
+
+// envelopeSecret returns the Vault secret data for an envelope.
+func envelopeSecret(env *envelope) map[string]interface{} {
+	secret := map[string]interface{}{
+		"version":  env.version,
+		"scheme":   env.scheme,
+		"checksum": checksum(env.data),
+		"data":     b64.URLEncoding.EncodeToString(env.data),
+	}
+	if env.kdf != "" {
+		secret["kdf"] = env.kdf
+		secret["kdf_iterations"] = env.kdfIterations
+	}
+	return secret
+}
+
+// parseEnvelope parses the Vault secret data of an envelope.
+func parseEnvelope(secret map[string]interface{}) (*envelope, error) {
+	env := &envelope{}
+	if version, exists := secret["version"]; exists {
+		var err error
+		env.version, err = envelopeInt(version)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid secret envelope version")
+		}
+		if env.version != envelopeVersion {
+			return nil, fmt.Errorf("unsupported secret envelope version %d", env.version)
+		}
+		env.scheme, _ = secret["scheme"].(string)
+		env.kdf, _ = secret["kdf"].(string)
+		if iterations, exists := secret["kdf_iterations"]; exists {
+			env.kdfIterations, err = envelopeInt(iterations)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid key derivation iterations")
+			}
+		}
+		env.checksum, _ = secret["checksum"].(string)
+	}
+
+	data, _ := secret["data"].(string)
+	var err error
+	env.data, err = b64.URLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode secret data")
+	}
+	return env, nil
+}
+
+// envelopeInt returns an integer field of an envelope, as decoded from Vault's JSON.
+func envelopeInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err
+	case float64:
+		return int(v), nil
+	case int:
+		return v, nil
+	case string:
+		return strconv.Atoi(v)
+	default:
+		return 0, fmt.Errorf("unexpected type %T", value)
+	}
+}
+
+// checksum returns the checksum of an envelope's data.
+func checksum(data []byte) string {
+	hash := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// UpgradeEnvelopes rewrites wallets, accounts, accounts indices and the public key index held in an earlier format
+// in the current envelope, encrypted as the store would now write them.  Secrets already in the current format are
+// skipped, so an interrupted upgrade can be resumed by running it again.  The public key index is counted with the
+// accounts indices.  Both migration options are honoured.
+// Secrets that cannot be read with the store's passphrase stop the upgrade with an error.  The upgrade should be run
+// while no other store is writing to the mount.
+func (s *Store) UpgradeEnvelopes(opts ...MigrationOption) (_ *MigrationReport, err error) {
+	ctx, op := s.startOperation(context.Background(), "upgrade_envelopes")
+	defer op.end(&err)
+	options := migrationOptions{}
+	for _, o := range opts {
+		o.apply(&options)
+	}
+
+	if !options.dryRun {
+		if err := s.checkWritable("upgrade envelopes"); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &MigrationReport{}
+	err = s.walkSecrets(ctx, func(ctx context.Context, path string, binding *secretBinding) error {
+		upgraded, err := s.upgradeEnvelope(ctx, path, binding, &options)
+		if errors.Is(err, vault.ErrSecretNotFound) && binding.kind != secretKindWallet && binding.kind != secretKindAccount {
+			// No index.
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to upgrade %s", binding))
+		}
+		switch binding.kind {
+		case secretKindWallet:
+			count(upgraded, &report.WalletsMigrated, &report.WalletsSkipped)
+		case secretKindAccount:
+			count(upgraded, &report.AccountsMigrated, &report.AccountsSkipped)
+		default:
+			count(upgraded, &report.IndicesMigrated, &report.IndicesSkipped)
+		}
+		return nil
+	})
+	return report, err
+}
+
+// walkSecrets visits the header, accounts and accounts index of each wallet, followed by the public key index,
+// stopping at the first error.  Indices are visited whether or not they exist.
+func (s *Store) walkSecrets(ctx context.Context, visit func(ctx context.Context, path string, binding *secretBinding) error) error {
+	walletIDs, err := s.listWalletIDs(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list wallets")
+	}
+	for _, key := range walletIDs {
+		walletID, err := uuid.Parse(key)
+		if err != nil {
+			continue
+		}
+		if err := visit(ctx, s.walletHeaderPath(walletID), walletBinding(walletID)); err != nil {
+			return err
+		}
+
+		accountIDs, err := s.listAccountIDs(ctx, walletID, s.account_layout)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to list accounts in wallet %s", walletID))
+		}
+		for _, key := range accountIDs {
+			accountID, err := uuid.Parse(key)
+			if err != nil {
+				continue
+			}
+			if err := visit(ctx, s.accountPath(walletID, accountID), accountBinding(walletID, accountID)); err != nil {
+				return err
+			}
+		}
+
+		if err := visit(ctx, s.walletIndexPath(walletID), indexBinding(walletID)); err != nil {
+			return err
+		}
+	}
+
+	return visit(ctx, s.publicKeyIndexPath(), publicKeyIndexBinding())
+}
+
+// upgradeEnvelope rewrites the secret at the given path in the current envelope, returning true if it was
+// not already current.
+func (s *Store) upgradeEnvelope(ctx context.Context, path string, binding *secretBinding, options *migrationOptions) (bool, error) {
+	secret, err := s.kvGet(ctx, path)
+	if err != nil {
+		if !errors.Is(err, vault.ErrSecretNotFound) {
+			s.audit(ctx, AuditRead, binding, err)
+		}
+		return false, err
+	}
+	env, err := parseEnvelope(secret)
+	if err != nil {
+		s.audit(ctx, AuditRead, binding, &decodeError{err: err})
+		return false, err
+	}
+	current := s.current(env, binding)
+	data, err := s.openEnvelope(env, binding)
+	if err != nil {
+		s.audit(ctx, AuditRead, binding, &decodeError{err: err})
+		return false, err
+	}
+	s.audit(ctx, AuditRead, binding, nil)
+	defer zero(data)
+	if current {
+		return false, nil
+	}
+	if options.dryRun {
+		return true, nil
+	}
+
+	upgraded, err := s.sealSecret(data, binding)
+	if err != nil {
+		return false, err
+	}
+	err = s.kvPut(ctx, path, upgraded)
+	s.audit(ctx, AuditWrite, binding, err)
+	if err != nil {
+		return false, err
+	}
+	if options.verify {
+		stored, err := s.kvGet(ctx, path)
+		if err != nil {
+			s.audit(ctx, AuditRead, binding, err)
+			return false, errors.Wrap(err, "failed to read upgraded secret")
+		}
+		storedData, err := s.openSecret(stored, binding)
+		s.audit(ctx, AuditRead, binding, err)
+		defer zero(storedData)
+		if err != nil || !bytes.Equal(storedData, data) {
+			return false, errors.New("verification of upgraded secret failed")
+		}
+	}
+	return true, nil
+}
+
+// count increments upgraded or skipped.
+func count(upgraded bool, upgradedCount *int, skippedCount *int) {
+	if upgraded {
+		*upgradedCount++
+	} else {
+		*skippedCount++
+	}
+}
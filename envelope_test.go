@@ -0,0 +1,193 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	vaultapi "github.com/hashicorp/vault/api"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wealdtech/go-ecodec"
+)
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath(mount),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "envelope wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"envelope wallet"}`, walletID))))
+	require.Nil(t, store.StoreAccountsIndex(walletID, []byte("{}")))
+
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken("golang-test")
+	kv := client.KVv1(mount)
+
+	secret, err := kv.Get(ctx, fmt.Sprintf("wallets/%s/%s", walletID, walletID))
+	require.Nil(t, err)
+	assert.Equal(t, json.Number("1"), secret.Data["version"])
+	assert.Equal(t, "aes-256-gcm", secret.Data["scheme"])
+	assert.Equal(t, "pbkdf2-sha256", secret.Data["kdf"])
+	assert.Equal(t, json.Number("262144"), secret.Data["kdf_iterations"])
+	assert.Contains(t, secret.Data["checksum"], "sha256:")
+
+	// The empty index is not encrypted.
+	secret, err = kv.Get(ctx, fmt.Sprintf("wallets/%s/index", walletID))
+	require.Nil(t, err)
+	assert.Equal(t, "none", secret.Data["scheme"])
+	assert.Equal(t, b64.URLEncoding.EncodeToString([]byte("{}")), secret.Data["data"])
+
+	// Corrupted data is caught by the checksum.
+	secret, err = kv.Get(ctx, fmt.Sprintf("wallets/%s/%s", walletID, walletID))
+	require.Nil(t, err)
+	accountID := uuid.New()
+	secret.Data["data"] = b64.URLEncoding.EncodeToString([]byte("corrupted account data"))
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID), secret.Data))
+	_, err = store.RetrieveAccount(walletID, accountID)
+	require.NotNil(t, err)
+	assert.Equal(t, "secret checksum mismatch", err.Error())
+
+	// Unknown envelope versions are rejected.
+	secret.Data["version"] = 2
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID), secret.Data))
+	_, err = store.RetrieveAccount(walletID, accountID)
+	require.NotNil(t, err)
+	assert.Equal(t, "unsupported secret envelope version 2", err.Error())
+
+	// Key derivation iterations other than those the store writes are rejected without deriving a key.
+	secret, err = kv.Get(ctx, fmt.Sprintf("wallets/%s/%s", walletID, walletID))
+	require.Nil(t, err)
+	secret.Data["kdf_iterations"] = 1 << 40
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID), secret.Data))
+	_, err = store.RetrieveAccount(walletID, accountID)
+	require.NotNil(t, err)
+	assert.Equal(t, "unsupported key derivation iterations 1099511627776", err.Error())
+}
+
+func TestUpgradeEnvelopes(t *testing.T) {
+	ctx := context.Background()
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	newStore := func(opts ...vault.Option) *vault.Store {
+		store, err := vault.New(append([]vault.Option{
+			vault.WithPassphrase([]byte("test")),
+			vault.WithVaultAddr("http://localhost:8200"),
+			vault.WithVaultSecretMountPath(mount),
+			vault.WithVaultToken("golang-test"),
+			vault.WithVaultAuth("token"),
+		}, opts...)...)
+		require.Nil(t, err)
+		return store.(*vault.Store)
+	}
+	store := newStore()
+
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken("golang-test")
+	kv := client.KVv1(mount)
+	putLegacy := func(path string, data []byte) {
+		encrypted, err := ecodec.Encrypt(data, []byte("test"))
+		require.Nil(t, err)
+		require.Nil(t, kv.Put(ctx, path, map[string]interface{}{
+			"data": b64.URLEncoding.EncodeToString(encrypted),
+		}))
+	}
+
+	// Write a wallet, an account and an index in the format used before envelopes.
+	walletID := uuid.New()
+	walletData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"legacy wallet"}`, walletID))
+	putLegacy(fmt.Sprintf("wallets/%s/%s", walletID, walletID), walletData)
+	accountID := uuid.New()
+	accountData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"legacy account"}`, accountID))
+	putLegacy(fmt.Sprintf("wallets/%s/%s", walletID, accountID), accountData)
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/index", walletID), map[string]interface{}{
+		"data": b64.URLEncoding.EncodeToString([]byte("{}")),
+	}))
+	// And one in the current format.
+	currentID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, currentID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"current account"}`, currentID))))
+
+	// Legacy secrets are readable.
+	data, err := store.RetrieveWalletByID(walletID)
+	require.Nil(t, err)
+	assert.Equal(t, walletData, data)
+	data, err = store.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, accountData, data)
+	data, err = store.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("{}"), data)
+
+	report, err := store.UpgradeEnvelopes(vault.WithMigrationDryRun())
+	require.Nil(t, err)
+	assert.Equal(t, &vault.MigrationReport{
+		WalletsMigrated:  1,
+		AccountsMigrated: 1,
+		AccountsSkipped:  1,
+		IndicesMigrated:  1,
+	}, report)
+	secret, err := kv.Get(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID))
+	require.Nil(t, err)
+	assert.Nil(t, secret.Data["version"])
+
+	report, err = store.UpgradeEnvelopes(vault.WithMigrationVerification())
+	require.Nil(t, err)
+	assert.Equal(t, &vault.MigrationReport{
+		WalletsMigrated:  1,
+		AccountsMigrated: 1,
+		AccountsSkipped:  1,
+		IndicesMigrated:  1,
+	}, report)
+	secret, err = kv.Get(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID))
+	require.Nil(t, err)
+	assert.Equal(t, json.Number("1"), secret.Data["version"])
+
+	// Upgraded secrets are bound, so are readable by a store that requires binding.
+	strictStore := newStore(vault.WithRequireBinding())
+	data, err = strictStore.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, accountData, data)
+	data, err = strictStore.RetrieveWalletByID(walletID)
+	require.Nil(t, err)
+	assert.Equal(t, walletData, data)
+
+	// A second upgrade has nothing to do.
+	report, err = store.UpgradeEnvelopes()
+	require.Nil(t, err)
+	assert.Equal(t, &vault.MigrationReport{
+		WalletsSkipped:  1,
+		AccountsSkipped: 2,
+		IndicesSkipped:  1,
+	}, report)
+}
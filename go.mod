@@ -5,14 +5,21 @@ go 1.12
 require (
 	github.com/aws/aws-sdk-go v1.40.41
 	github.com/google/uuid v1.3.0
-	github.com/hashicorp/vault/api v1.8.0 // indirect
+	github.com/hashicorp/vault/api v1.8.0
+	github.com/hashicorp/vault/api/auth/approle v0.3.0
 	github.com/hashicorp/vault/api/auth/aws v0.3.0 // indirect
-	github.com/hashicorp/vault/api/auth/kubernetes v0.3.0 // indirect
+	github.com/hashicorp/vault/api/auth/kubernetes v0.3.0
 	github.com/pkg/errors v0.9.1
-	github.com/stretchr/testify v1.7.0
+	github.com/prometheus/client_golang v1.10.0
+	github.com/rs/zerolog v1.23.0
+	github.com/stretchr/testify v1.8.2
 	github.com/wealdtech/go-ecodec v1.1.2
 	github.com/wealdtech/go-eth2-util v1.7.0
 	github.com/wealdtech/go-eth2-wallet-types/v2 v2.9.0
 	github.com/wealdtech/go-indexer v1.0.0
-	golang.org/x/sys v0.0.0-20210910150752-751e447fb3d0 // indirect
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/sys v0.0.0-20210910150752-751e447fb3d0
+	gopkg.in/yaml.v3 v3.0.1
 )
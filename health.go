@@ -0,0 +1,101 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Health is the health of the store.
+type Health struct {
+	// Sealed is true if the Vault server is sealed.
+	Sealed bool
+	// TokenTTL is the time remaining before the client token expires.  It is 0 if the token does not expire.
+	TokenTTL time.Duration
+	// MountReachable is true if the wallets in the secrets mount can be listed.
+	MountReachable bool
+	// Capabilities are the capabilities of the client token on the wallet path.
+	Capabilities []string
+	// CanRead is true if the client token can read wallets and accounts.
+	CanRead bool
+	// CanWrite is true if the client token can write wallets and accounts.
+	CanWrite bool
+	// ReadOnly is true if the store is read-only, in which case it does not need to write.
+	ReadOnly bool
+	// Errors are the errors encountered when checking the health of the store.
+	Errors []error
+}
+
+// Ready returns true if the store can be used.  A read-only store need only be able to read.
+func (h *Health) Ready() bool {
+	return !h.Sealed && h.MountReachable && h.CanRead && (h.CanWrite || h.ReadOnly)
+}
+
+// Health checks the health of the store.
+// It returns an error only if the Vault server cannot be contacted; problems with the store itself are reported in the
+// returned health.
+func (s *Store) Health(ctx context.Context) (*Health, error) {
+	health := &Health{
+		ReadOnly: s.read_only,
+	}
+
+	sealStatus, err := s.client.Sys().SealStatusWithContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to obtain seal status")
+	}
+	health.Sealed = sealStatus.Sealed
+	if health.Sealed {
+		// Nothing else can be checked whilst sealed.
+		return health, nil
+	}
+
+	tokenInfo, err := s.client.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		health.Errors = append(health.Errors, errors.Wrap(err, "failed to look up token"))
+	} else {
+		health.TokenTTL, err = tokenInfo.TokenTTL()
+		if err != nil {
+			health.Errors = append(health.Errors, errors.Wrap(err, "failed to obtain token TTL"))
+		}
+	}
+
+	if _, err := s.kvList(ctx, "wallets"); err != nil {
+		health.Errors = append(health.Errors, errors.Wrap(err, "failed to list wallets"))
+	} else {
+		health.MountReachable = true
+	}
+
+	health.Capabilities, err = s.client.Sys().CapabilitiesSelfWithContext(ctx, s.kvDataPath("wallets/"))
+	if err != nil {
+		health.Errors = append(health.Errors, errors.Wrap(err, "failed to obtain capabilities"))
+	} else {
+		health.CanRead = hasCapability(health.Capabilities, "read")
+		health.CanWrite = hasCapability(health.Capabilities, "create") && hasCapability(health.Capabilities, "update")
+	}
+
+	return health, nil
+}
+
+// hasCapability returns true if the capabilities include the given capability.
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability || c == "root" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createPolicyToken creates a policy with the given rules and returns a token that holds it.
+func createPolicyToken(t *testing.T, name string, rules string) string {
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken("golang-test")
+
+	require.Nil(t, client.Sys().PutPolicy(name, rules))
+	secret, err := client.Auth().Token().Create(&vaultapi.TokenCreateRequest{
+		Policies: []string{name},
+	})
+	require.Nil(t, err)
+	return secret.Auth.ClientToken
+}
+
+func TestHealth(t *testing.T) {
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+
+	health, err := store.(*vault.Store).Health(context.Background())
+	require.Nil(t, err)
+	assert.False(t, health.Sealed)
+	assert.True(t, health.MountReachable)
+	assert.True(t, health.CanRead)
+	assert.True(t, health.CanWrite)
+	assert.Empty(t, health.Errors)
+	assert.True(t, health.Ready())
+}
+
+func TestHealthReadOnlyToken(t *testing.T) {
+	token := createPolicyToken(t, "wallets-health-read", `
+path "secret/metadata/wallets" {
+  capabilities = ["list"]
+}
+path "secret/data/wallets/*" {
+  capabilities = ["read"]
+}
+`)
+	store, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultKVVersion(2),
+		vault.WithVaultToken(token),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+
+	health, err := store.(*vault.Store).Health(context.Background())
+	require.Nil(t, err)
+	assert.False(t, health.Sealed)
+	assert.True(t, health.TokenTTL > 0)
+	assert.True(t, health.MountReachable)
+	assert.True(t, health.CanRead)
+	assert.False(t, health.CanWrite)
+	assert.False(t, health.Ready())
+
+	// A read-only store is ready with the same token.
+	store, err = vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultKVVersion(2),
+		vault.WithVaultToken(token),
+		vault.WithVaultAuth("token"),
+		vault.WithReadOnly(),
+	)
+	require.Nil(t, err)
+
+	health, err = store.(*vault.Store).Health(context.Background())
+	require.Nil(t, err)
+	assert.True(t, health.ReadOnly)
+	assert.False(t, health.CanWrite)
+	assert.True(t, health.Ready())
+}
@@ -15,7 +15,6 @@ package vaultstorage
 
 import (
 	"context"
-	b64 "encoding/base64"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -26,48 +25,43 @@ type WalletIndexSecret struct {
 }
 
 // StoreAccountsIndex stores the account index.
-func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
-	var err error
+func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) (err error) {
+	ctx, op := s.startOperation(context.Background(), "store_accounts_index")
+	defer op.end(&err)
+	if err := s.checkWritable("store accounts index"); err != nil {
+		return err
+	}
 
-	// Do not encrypt empty index.
-	if len(data) != 2 {
-		data, err = s.encryptIfRequired(data)
-		if err != nil {
-			return err
-		}
+	secret, err := s.sealSecret(data, indexBinding(walletID))
+	if err != nil {
+		return err
 	}
 
 	path := s.walletIndexPath(walletID)
 
-	sEnc := b64.URLEncoding.EncodeToString(data)
-	s.client.KVv2(s.vault_secrets_mount_path).Put(context.Background(), path, map[string]interface{}{
-		"data": sEnc,
-	})
-
+	err = s.kvPut(ctx, path, secret)
 	if err != nil {
 		return errors.Wrap(err, "failed to store wallet index")
 	}
 
-	return nil
+	return s.storeCustomMetadata(ctx, path, &Metadata{
+		Type:     "index",
+		WalletID: walletID,
+	})
 }
 
 // RetrieveAccountsIndex retrieves the account index.
-func (s *Store) RetrieveAccountsIndex(walletID uuid.UUID) ([]byte, error) {
+func (s *Store) RetrieveAccountsIndex(walletID uuid.UUID) (_ []byte, err error) {
+	ctx, op := s.startOperation(context.Background(), "retrieve_accounts_index")
+	defer op.end(&err)
 	path := s.walletIndexPath(walletID)
 
-	secret, err := s.client.KVv2(s.vault_secrets_mount_path).Get(context.Background(), path)
+	secret, err := s.kvGet(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 
-	returnedData, _ := secret.Data["data"].(string)
-
-	sDec, _ := b64.URLEncoding.DecodeString(returnedData)
-	// Do not decrypt empty index.
-	if len(sDec) == 2 {
-		return sDec, nil
-	}
-	data, err := s.decryptIfRequired(sDec)
+	data, err := s.openSecret(secret, indexBinding(walletID))
 	if err != nil {
 		return nil, err
 	}
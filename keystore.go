@@ -0,0 +1,244 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	indexer "github.com/wealdtech/go-indexer"
+)
+
+// nonDeterministicWalletType is the type of wallet in to which keystores are imported.
+const nonDeterministicWalletType = "non-deterministic"
+
+// keystore is an EIP-2335 keystore.
+type keystore struct {
+	Crypto  map[string]interface{} `json:"crypto"`
+	PubKey  string                 `json:"pubkey"`
+	UUID    string                 `json:"uuid"`
+	Version uint                   `json:"version"`
+}
+
+// keystoreWallet is the wallet-level data of a non-deterministic wallet.
+type keystoreWallet struct {
+	ID      uuid.UUID `json:"uuid"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Version uint      `json:"version"`
+}
+
+// keystoreAccount is the account-level data of an account in a non-deterministic wallet.
+type keystoreAccount struct {
+	ID        uuid.UUID              `json:"uuid"`
+	Name      string                 `json:"name"`
+	PubKey    string                 `json:"pubkey"`
+	Crypto    map[string]interface{} `json:"crypto"`
+	Encryptor string                 `json:"encryptor"`
+	Version   uint                   `json:"version"`
+}
+
+// KeystoreImportReport is the result of importing keystores.
+type KeystoreImportReport struct {
+	// WalletID is the ID of the wallet in to which the keystores were imported.
+	WalletID uuid.UUID
+	// WalletCreated is true if the wallet was created by the import.
+	WalletCreated bool
+	// Imported are the names of the accounts created.
+	Imported []string
+	// Duplicates are the paths of the keystores not imported because an account with the same public key exists.
+	Duplicates []string
+}
+
+// ImportKeystores imports the EIP-2335 keystores in a directory as accounts of a non-deterministic wallet.
+// The wallet is created if it does not exist.  Each account is named after its keystore file, without the
+// extension.  Keystores whose public key is already held in the wallet are not imported; accounts are found by the
+// public key index, so it should be rebuilt first if the wallet holds accounts stored before it was maintained.
+func (s *Store) ImportKeystores(dir string, walletName string) (*KeystoreImportReport, error) {
+	if err := s.checkWritable("import keystores"); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list keystores")
+	}
+	sort.Strings(paths)
+	keystores := make([]*keystore, len(paths))
+	for i, path := range paths {
+		keystores[i], err = readKeystore(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &KeystoreImportReport{}
+	wallet, created, err := s.keystoreWallet(walletName)
+	if err != nil {
+		return nil, err
+	}
+	report.WalletID = wallet.ID
+	report.WalletCreated = created
+
+	index := indexer.New()
+	data, err := s.RetrieveAccountsIndex(wallet.ID)
+	switch {
+	case err == nil:
+		index, err = indexer.Deserialize(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse accounts index")
+		}
+	case !errors.Is(err, vault.ErrSecretNotFound):
+		return nil, errors.Wrap(err, "failed to retrieve accounts index")
+	}
+
+	// Public keys imported here are tracked as well as indexed, as indexing is best-effort.
+	pubKeys := make(map[string]bool)
+	for i, path := range paths {
+		ks := keystores[i]
+		pubKey := normalizePubKey(ks.PubKey)
+		duplicate, err := s.keystoreDuplicate(wallet.ID, pubKey)
+		if err != nil {
+			return report, errors.Wrap(err, fmt.Sprintf("failed to check keystore %s", path))
+		}
+		if duplicate || pubKeys[pubKey] {
+			report.Duplicates = append(report.Duplicates, path)
+			continue
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if index.NameKnown(name) {
+			return report, fmt.Errorf("account %s already exists with a different public key", name)
+		}
+		id, err := uuid.Parse(ks.UUID)
+		if err != nil {
+			id = uuid.New()
+		}
+		data, err := json.Marshal(&keystoreAccount{
+			ID:        id,
+			Name:      name,
+			PubKey:    pubKey,
+			Crypto:    ks.Crypto,
+			Encryptor: "keystore",
+			Version:   ks.Version,
+		})
+		if err != nil {
+			return report, errors.Wrap(err, fmt.Sprintf("failed to create account for %s", path))
+		}
+		if err := s.StoreAccount(wallet.ID, id, data); err != nil {
+			return report, errors.Wrap(err, fmt.Sprintf("failed to store account for %s", path))
+		}
+		pubKeys[pubKey] = true
+		report.Imported = append(report.Imported, name)
+
+		index.Add(id, name)
+		indexData, err := index.Serialize()
+		if err != nil {
+			return report, errors.Wrap(err, "failed to create accounts index")
+		}
+		if err := s.StoreAccountsIndex(wallet.ID, indexData); err != nil {
+			return report, errors.Wrap(err, "failed to store accounts index")
+		}
+	}
+
+	return report, nil
+}
+
+// keystoreWallet obtains the named non-deterministic wallet, creating it if it does not exist.
+func (s *Store) keystoreWallet(name string) (*keystoreWallet, bool, error) {
+	if name == "" {
+		return nil, false, errors.New("wallet name missing")
+	}
+	wallet := &keystoreWallet{}
+	data, err := s.RetrieveWallet(name)
+	if err != nil && !errors.Is(err, errWalletNotFound) {
+		return nil, false, errors.Wrap(err, "failed to retrieve wallet")
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, wallet); err != nil {
+			return nil, false, errors.Wrap(err, "failed to parse wallet")
+		}
+		if wallet.Type != nonDeterministicWalletType {
+			return nil, false, fmt.Errorf("wallet %s is not a %s wallet", name, nonDeterministicWalletType)
+		}
+		return wallet, false, nil
+	}
+
+	wallet = &keystoreWallet{
+		ID:      uuid.New(),
+		Name:    name,
+		Type:    nonDeterministicWalletType,
+		Version: 1,
+	}
+	data, err = json.Marshal(wallet)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to create wallet")
+	}
+	if err := s.StoreWallet(wallet.ID, wallet.Name, data); err != nil {
+		return nil, false, errors.Wrap(err, "failed to store wallet")
+	}
+	return wallet, true, nil
+}
+
+// keystoreDuplicate returns true if an account with the given public key is held in the wallet.
+func (s *Store) keystoreDuplicate(walletID uuid.UUID, pubKey string) (bool, error) {
+	key, err := hex.DecodeString(pubKey)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid public key")
+	}
+	heldWalletID, _, err := s.RetrieveAccountByPublicKey(key)
+	if errors.Is(err, errAccountNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return heldWalletID == walletID, nil
+}
+
+// readKeystore reads and checks an EIP-2335 keystore.
+func readKeystore(path string) (*keystore, error) {
+	// #nosec G304
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read keystore %s", path))
+	}
+	ks := &keystore{}
+	if err := json.Unmarshal(data, ks); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to parse keystore %s", path))
+	}
+	if ks.Version != 4 {
+		return nil, fmt.Errorf("keystore %s has unsupported version %d", path, ks.Version)
+	}
+	if ks.Crypto == nil {
+		return nil, fmt.Errorf("keystore %s has no crypto", path)
+	}
+	if ks.PubKey == "" {
+		return nil, fmt.Errorf("keystore %s has no public key", path)
+	}
+	return ks, nil
+}
+
+// normalizePubKey returns a public key as lower-case hex without a prefix.
+func normalizePubKey(pubKey string) string {
+	return strings.TrimPrefix(strings.ToLower(pubKey), "0x")
+}
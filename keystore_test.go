@@ -0,0 +1,134 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	indexer "github.com/wealdtech/go-indexer"
+)
+
+// writeKeystore writes a keystore with the given public key to a directory.
+func writeKeystore(t *testing.T, dir string, name string, pubKey string) {
+	data := fmt.Sprintf(`{"crypto":{"kdf":{"function":"pbkdf2"},"checksum":{"function":"sha256"},"cipher":{"function":"aes-128-ctr"}},"description":"","pubkey":%q,"path":"m/12381/3600/0/0/0","uuid":%q,"version":4}`, pubKey, uuid.New())
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, name+".json"), []byte(data), 0600))
+}
+
+func TestImportKeystores(t *testing.T) {
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+	vaultStore := store.(*vault.Store)
+
+	dir := t.TempDir()
+	writeKeystore(t, dir, "keystore-0", "a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c")
+	writeKeystore(t, dir, "keystore-1", "0xb89bebc699769726a318c8e9971bd3171297c61aea4a6578a7a4f94b547dcba5bac16a89108b6b6a1fe3695d1a874a0b")
+	walletName := fmt.Sprintf("keystore wallet %s", uuid.New())
+
+	report, err := vaultStore.ImportKeystores(dir, walletName)
+	require.Nil(t, err)
+	assert.True(t, report.WalletCreated)
+	assert.Equal(t, []string{"keystore-0", "keystore-1"}, report.Imported)
+	assert.Empty(t, report.Duplicates)
+
+	walletData, err := store.RetrieveWallet(walletName)
+	require.Nil(t, err)
+	wallet := make(map[string]interface{})
+	require.Nil(t, json.Unmarshal(walletData, &wallet))
+	assert.Equal(t, "non-deterministic", wallet["type"])
+
+	indexData, err := store.RetrieveAccountsIndex(report.WalletID)
+	require.Nil(t, err)
+	index, err := indexer.Deserialize(indexData)
+	require.Nil(t, err)
+	accountID, exists := index.ID("keystore-1")
+	require.True(t, exists)
+	accountData, err := store.RetrieveAccount(report.WalletID, accountID)
+	require.Nil(t, err)
+	account := make(map[string]interface{})
+	require.Nil(t, json.Unmarshal(accountData, &account))
+	assert.Equal(t, "b89bebc699769726a318c8e9971bd3171297c61aea4a6578a7a4f94b547dcba5bac16a89108b6b6a1fe3695d1a874a0b", account["pubkey"])
+	assert.Equal(t, "keystore", account["encryptor"])
+	assert.NotNil(t, account["crypto"])
+
+	// Importing again, with a further keystore and a copy of an existing one, only adds the new keystore.
+	writeKeystore(t, dir, "keystore-2", "8c9a6ab4a3ac8fdd4b1b8b5a5e9ea7d2c7c8cbea26bbd66b1d0bf3e5f4be7e8ff7b57e5a1dc2bd0f3fdbdbd8bb5fd7c8")
+	writeKeystore(t, dir, "keystore-3", "A99A76ED7796F7BE22D5B7E85DEEB7C5677E88E511E0B337618F8C4EB61349B4BF2D153F649F7B53359FE8B94A38E44C")
+	report, err = vaultStore.ImportKeystores(dir, walletName)
+	require.Nil(t, err)
+	assert.False(t, report.WalletCreated)
+	assert.Equal(t, []string{"keystore-2"}, report.Imported)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "keystore-0.json"),
+		filepath.Join(dir, "keystore-1.json"),
+		filepath.Join(dir, "keystore-3.json"),
+	}, report.Duplicates)
+
+	indexData, err = store.RetrieveAccountsIndex(report.WalletID)
+	require.Nil(t, err)
+	index, err = indexer.Deserialize(indexData)
+	require.Nil(t, err)
+	_, exists = index.ID("keystore-2")
+	assert.True(t, exists)
+}
+
+func TestImportKeystoresBadKeystore(t *testing.T) {
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+
+	dir := t.TempDir()
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"crypto":{},"pubkey":"a99a","version":3}`), 0600))
+	_, err = store.(*vault.Store).ImportKeystores(dir, fmt.Sprintf("keystore wallet %s", uuid.New()))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unsupported version 3")
+}
+
+func TestImportKeystoresExistingAccount(t *testing.T) {
+	store := newFreshMountStore(t)
+	dir := t.TempDir()
+	writeKeystore(t, dir, "keystore-0", "a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c")
+	report, err := store.ImportKeystores(dir, "keystore wallet")
+	require.Nil(t, err)
+
+	// An account stored other than by import is found by its public key.
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(report.WalletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"existing account","pubkey":"0xb89bebc699769726a318c8e9971bd3171297c61aea4a6578a7a4f94b547dcba5bac16a89108b6b6a1fe3695d1a874a0b"}`, accountID))))
+	writeKeystore(t, dir, "keystore-1", "b89bebc699769726a318c8e9971bd3171297c61aea4a6578a7a4f94b547dcba5bac16a89108b6b6a1fe3695d1a874a0b")
+	report, err = store.ImportKeystores(dir, "keystore wallet")
+	require.Nil(t, err)
+	assert.Empty(t, report.Imported)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "keystore-0.json"),
+		filepath.Join(dir, "keystore-1.json"),
+	}, report.Duplicates)
+}
@@ -0,0 +1,230 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// detectKVVersion obtains the version of the KV secrets engine mounted at the given path.
+func detectKVVersion(ctx context.Context, client *vault.Client, mountPath string) (int, error) {
+	mountPath = strings.Trim(mountPath, "/")
+
+	// The UI mounts endpoint is available to any token with access to the mount, so try it first.
+	secret, err := client.Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+mountPath)
+	if err == nil && secret != nil && secret.Data != nil {
+		mountType, _ := secret.Data["type"].(string)
+		if mountType != "kv" {
+			return 0, fmt.Errorf("secrets engine mounted at %s is of type %s rather than kv", mountPath, mountType)
+		}
+		options, _ := secret.Data["options"].(map[string]interface{})
+		if options != nil {
+			version, _ := options["version"].(string)
+			return parseKVVersion(version)
+		}
+		return 1, nil
+	}
+
+	// Fall back to the full mount table, which requires read access to sys/mounts.
+	mounts, err := client.Sys().ListMountsWithContext(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to obtain secrets engine mounts")
+	}
+	mount, exists := mounts[mountPath+"/"]
+	if !exists {
+		return 0, fmt.Errorf("no secrets engine mounted at %s", mountPath)
+	}
+	if mount.Type != "kv" {
+		return 0, fmt.Errorf("secrets engine mounted at %s is of type %s rather than kv", mountPath, mount.Type)
+	}
+	return parseKVVersion(mount.Options["version"])
+}
+
+// parseKVVersion parses the version option of a KV secrets engine mount.
+func parseKVVersion(version string) (int, error) {
+	switch version {
+	case "", "1":
+		return 1, nil
+	case "2":
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("unsupported KV secrets engine version %q", version)
+	}
+}
+
+// kvGet obtains the data of the secret at the given path.
+func (s *Store) kvGet(ctx context.Context, path string) (map[string]interface{}, error) {
+	data, _, err := s.kvGetVersion(ctx, path)
+	return data, err
+}
+
+// kvGetVersion obtains the data of the secret at the given path, along with its version.  The version is always 0
+// with KV version 1, which does not version secrets.
+func (s *Store) kvGetVersion(ctx context.Context, path string) (map[string]interface{}, int, error) {
+	var secret *vault.KVSecret
+	var err error
+	ctx, req := s.startRequest(ctx, "read", s.kvDataPath(path))
+	err = s.withReadClient(func(client *vault.Client) error {
+		var err error
+		if s.vault_kv_version == 1 {
+			secret, err = client.KVv1(s.vault_secrets_mount_path).Get(ctx, path)
+		} else {
+			secret, err = client.KVv2(s.vault_secrets_mount_path).Get(ctx, path)
+		}
+		return err
+	})
+	if secret != nil && secret.Raw != nil {
+		req.requestID = secret.Raw.RequestID
+	}
+	req.end(err)
+	if err != nil {
+		return nil, 0, err
+	}
+	version := 0
+	if secret.VersionMetadata != nil {
+		version = secret.VersionMetadata.Version
+	}
+	return secret.Data, version, nil
+}
+
+// kvPut writes data to the secret at the given path.  Options, such as check-and-set, apply to KV version 2 only.
+func (s *Store) kvPut(ctx context.Context, path string, data map[string]interface{}, opts ...vault.KVOption) error {
+	var err error
+	ctx, req := s.startRequest(ctx, "write", s.kvDataPath(path))
+	if s.vault_kv_version == 1 {
+		err = s.client.KVv1(s.vault_secrets_mount_path).Put(ctx, path, data)
+	} else {
+		var secret *vault.KVSecret
+		secret, err = s.client.KVv2(s.vault_secrets_mount_path).Put(ctx, path, data, opts...)
+		if secret != nil && secret.Raw != nil {
+			req.requestID = secret.Raw.RequestID
+		}
+	}
+	req.end(err)
+	return err
+}
+
+// kvDelete permanently deletes the secret at the given path, including all versions.
+func (s *Store) kvDelete(ctx context.Context, path string) error {
+	var err error
+	ctx, req := s.startRequest(ctx, "delete", s.kvDataPath(path))
+	if s.vault_kv_version == 1 {
+		err = s.client.KVv1(s.vault_secrets_mount_path).Delete(ctx, path)
+	} else {
+		err = s.client.KVv2(s.vault_secrets_mount_path).DeleteMetadata(ctx, path)
+	}
+	req.end(err)
+	return err
+}
+
+// kvList lists the keys under the given path.
+// Keys ending in "/" are directories.  A path that does not exist returns no keys.
+func (s *Store) kvList(ctx context.Context, path string) ([]string, error) {
+	ctx, req := s.startRequest(ctx, "list", s.kvMetadataPath(path))
+	var secret *vault.Secret
+	err := s.withReadClient(func(client *vault.Client) error {
+		var err error
+		secret, err = client.Logical().ListWithContext(ctx, s.kvMetadataPath(path))
+		return err
+	})
+	req.end(err)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+	items, _ := secret.Data["keys"].([]interface{})
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if key, isString := item.(string); isString {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// kvPutCustomMetadata sets the custom metadata of the secret at the given path.  It requires KV version 2.
+// Other metadata, such as the maximum number of versions, is left unchanged.
+func (s *Store) kvPutCustomMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	ctx, req := s.startRequest(ctx, "write_metadata", s.kvMetadataPath(path))
+	_, err := s.client.Logical().WriteWithContext(ctx, s.kvMetadataPath(path), map[string]interface{}{
+		"custom_metadata": metadata,
+	})
+	req.end(err)
+	return err
+}
+
+// kvGetCustomMetadata obtains the custom metadata of the secret at the given path.  It requires KV version 2.
+func (s *Store) kvGetCustomMetadata(ctx context.Context, path string) (map[string]string, error) {
+	ctx, req := s.startRequest(ctx, "read_metadata", s.kvMetadataPath(path))
+	var metadata *vault.KVMetadata
+	err := s.withReadClient(func(client *vault.Client) error {
+		var err error
+		metadata, err = client.KVv2(s.vault_secrets_mount_path).GetMetadata(ctx, path)
+		return err
+	})
+	req.end(err)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string, len(metadata.CustomMetadata))
+	for key, value := range metadata.CustomMetadata {
+		if str, isString := value.(string); isString {
+			res[key] = str
+		}
+	}
+	return res, nil
+}
+
+// kvDataPath returns the full Vault path at which the data for the given path is held.
+func (s *Store) kvDataPath(path string) string {
+	if s.vault_kv_version == 1 {
+		return fmt.Sprintf("%s/%s", s.vault_secrets_mount_path, path)
+	}
+	return fmt.Sprintf("%s/data/%s", s.vault_secrets_mount_path, path)
+}
+
+// kvMetadataPath returns the full Vault path at which the metadata for the given path is held.
+// This is also the path against which keys are listed.
+func (s *Store) kvMetadataPath(path string) string {
+	if s.vault_kv_version == 1 {
+		return fmt.Sprintf("%s/%s", s.vault_secrets_mount_path, path)
+	}
+	return fmt.Sprintf("%s/metadata/%s", s.vault_secrets_mount_path, path)
+}
+
+// withReadClient makes a read request with each of the store's read clients in turn, starting with the next in
+// rotation and finishing with the client for the active node, until one is available.
+func (s *Store) withReadClient(read func(*vault.Client) error) error {
+	if len(s.readClients) == 0 {
+		return read(s.client)
+	}
+	start := atomic.AddUint32(&s.readNext, 1)
+	for i := range s.readClients {
+		client := s.readClients[(start+uint32(i))%uint32(len(s.readClients))]
+		err := read(client)
+		if !unavailable(err) {
+			return err
+		}
+		s.logger.Warn("Vault read address unavailable", "addr", client.Address(), "error", err)
+	}
+	return read(s.client)
+}
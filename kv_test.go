@@ -0,0 +1,228 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	vaultapi "github.com/hashicorp/vault/api"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mountKVv1 ensures that a version 1 KV secrets engine is mounted at the given path.
+func mountKVv1(t *testing.T, path string) {
+	mountKV(t, path, "1")
+}
+
+// mountKV ensures that a KV secrets engine of the given version is mounted at the given path.
+func mountKV(t *testing.T, path string, version string) {
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken("golang-test")
+
+	err = client.Sys().Mount(path, &vaultapi.MountInput{
+		Type:    "kv",
+		Options: map[string]string{"version": version},
+	})
+	if err != nil && !strings.Contains(err.Error(), "path is already in use") {
+		t.Fatal(err)
+	}
+}
+
+// newFreshMountStore creates an encrypted store on a fresh KV version 1 mount, isolated from other tests.
+func newFreshMountStore(t *testing.T) *vault.Store {
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath(mount),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+	return store.(*vault.Store)
+}
+
+// proxyVault is a proxy to the test Vault server that records the requests it receives, and responds as a sealed
+// server whilst down is set.
+type proxyVault struct {
+	URL      string
+	down     int32
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newProxyVault(t *testing.T) *proxyVault {
+	target, err := url.Parse("http://localhost:8200")
+	require.Nil(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	res := &proxyVault{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res.mu.Lock()
+		res.requests = append(res.requests, r.Clone(r.Context()))
+		res.mu.Unlock()
+		if atomic.LoadInt32(&res.down) != 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"errors":["Vault is sealed"]}`)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	res.URL = server.URL
+	return res
+}
+
+// received returns the requests received since the given number of requests.
+func (p *proxyVault) received(since int) []*http.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*http.Request(nil), p.requests[since:]...)
+}
+
+func TestStoreRetrieveKVv1(t *testing.T) {
+	mountKVv1(t, "kv1")
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("kv1"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walletID := uuid.New()
+	walletName := fmt.Sprintf("test wallet %s", walletID)
+	walletData := []byte(fmt.Sprintf(`{"name":%q,"uuid":%q}`, walletName, walletID.String()))
+	accountID := uuid.New()
+	accountName := "test account"
+	accountData := []byte(fmt.Sprintf(`{"name":%q,"uuid":%q}`, accountName, accountID.String()))
+	indexData := []byte(`{"index":"test index data"}`)
+
+	err = store.StoreWallet(walletID, walletName, walletData)
+	require.Nil(t, err)
+	retWalletData, err := store.RetrieveWallet(walletName)
+	require.Nil(t, err)
+	assert.Equal(t, walletData, retWalletData)
+
+	err = store.StoreAccount(walletID, accountID, accountData)
+	require.Nil(t, err)
+	retAccountData, err := store.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, accountData, retAccountData)
+
+	accounts := 0
+	for range store.RetrieveAccounts(walletID) {
+		accounts++
+	}
+	assert.Equal(t, 1, accounts)
+
+	err = store.StoreAccountsIndex(walletID, indexData)
+	require.Nil(t, err)
+	retIndexData, err := store.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.Equal(t, indexData, retIndexData)
+}
+
+func TestInvalidKVVersion(t *testing.T) {
+	mountKVv1(t, "kv1")
+	_, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("kv1"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithVaultKVVersion(3),
+	)
+	assert.NotNil(t, err)
+}
+
+func TestNonKVMount(t *testing.T) {
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken("golang-test")
+	mount := fmt.Sprintf("transit-%s", uuid.New())
+	require.Nil(t, client.Sys().Mount(mount, &vaultapi.MountInput{Type: "transit"}))
+
+	_, err = vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath(mount),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is of type transit rather than kv")
+}
+
+func TestReadAddrs(t *testing.T) {
+	// Fail fast when the read address is down.
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+	standby := newProxyVault(t)
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKV(t, mount, "2")
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultReadAddrs([]string{standby.URL}),
+		vault.WithVaultSecretMountPath(mount),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+
+	since := len(standby.received(0))
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "standby wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"standby wallet"}`, walletID))))
+	accountID := uuid.New()
+	accountData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"standby account"}`, accountID))
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData))
+	accounts := 0
+	for range store.RetrieveAccounts(walletID) {
+		accounts++
+	}
+	assert.Equal(t, 1, accounts)
+
+	// Reads are sent to the read address with the replication state of earlier writes; writes are not.
+	requests := standby.received(since)
+	require.NotEmpty(t, requests)
+	for _, request := range requests {
+		assert.Contains(t, []string{http.MethodGet, "LIST"}, request.Method)
+		assert.NotEmpty(t, request.Header.Get("X-Vault-Index"))
+		assert.Equal(t, "golang-test", request.Header.Get("X-Vault-Token"))
+	}
+
+	// Reads fall back to the active node whilst the read address is unavailable.
+	atomic.StoreInt32(&standby.down, 1)
+	data, err := store.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, accountData, data)
+}
@@ -0,0 +1,182 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// AccountLayoutFlat holds accounts directly under their wallet, at wallets/<wallet ID>/<account ID>.
+	AccountLayoutFlat = "flat"
+	// AccountLayoutSharded holds accounts in 256 shards under their wallet, selected by a hash of the account ID,
+	// at wallets/<wallet ID>/accounts/<shard>/<account ID>.  This keeps each listing small for wallets with many accounts.
+	AccountLayoutSharded = "sharded"
+)
+
+// accountShard returns the shard in which an account is held in the sharded layout.
+func accountShard(accountID uuid.UUID) string {
+	hash := sha256.Sum256([]byte(accountID.String()))
+	return hex.EncodeToString(hash[:1])
+}
+
+// listAccountIDs lists the IDs of the accounts of a wallet held in the given layout.
+func (s *Store) listAccountIDs(ctx context.Context, walletID uuid.UUID, layout string) ([]string, error) {
+	walletPath := "wallets/" + s.walletPath(walletID)
+	if layout == AccountLayoutSharded {
+		shards, err := s.kvList(ctx, walletPath+"/accounts")
+		if err != nil {
+			return nil, err
+		}
+		accountIDs := make([]string, 0)
+		for _, shard := range shards {
+			if !strings.HasSuffix(shard, "/") {
+				continue
+			}
+			keys, err := s.kvList(ctx, walletPath+"/accounts/"+strings.TrimSuffix(shard, "/"))
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				if !strings.HasSuffix(key, "/") {
+					accountIDs = append(accountIDs, key)
+				}
+			}
+		}
+		return accountIDs, nil
+	}
+
+	keys, err := s.kvList(ctx, walletPath)
+	if err != nil {
+		return nil, err
+	}
+	accountIDs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") || key == walletID.String() || key == "index" {
+			// Directory, wallet or index.
+			continue
+		}
+		accountIDs = append(accountIDs, key)
+	}
+	return accountIDs, nil
+}
+
+// MigrateAccountLayout moves accounts held in any other layout in to the layout of the store.
+// It should be run after changing the layout of an existing store, as accounts in other layouts are not visible.
+// Accounts are copied, checked and then removed from their previous location, so an interrupted migration can be
+// resumed by running it again.  The dry run option is honoured; verification always takes place.
+// Accounts are moved without being decrypted, and any failure stops the migration rather than being skipped.
+func (s *Store) MigrateAccountLayout(opts ...MigrationOption) (_ *MigrationReport, err error) {
+	ctx, op := s.startOperation(context.Background(), "migrate_account_layout")
+	defer op.end(&err)
+	options := migrationOptions{}
+	for _, o := range opts {
+		o.apply(&options)
+	}
+
+	if !options.dryRun {
+		if err := s.checkWritable("migrate account layout"); err != nil {
+			return nil, err
+		}
+	}
+
+	previousLayout := AccountLayoutFlat
+	if s.account_layout == AccountLayoutFlat {
+		previousLayout = AccountLayoutSharded
+	}
+
+	report := &MigrationReport{}
+	// Wallets are listed rather than retrieved, so accounts move whether or not their wallet can be decrypted.
+	walletIDs, err := s.listWalletIDs(ctx)
+	if err != nil {
+		return report, errors.Wrap(err, "failed to list wallets")
+	}
+	for _, key := range walletIDs {
+		walletID, err := uuid.Parse(key)
+		if err != nil {
+			continue
+		}
+		accountIDs, err := s.listAccountIDs(ctx, walletID, previousLayout)
+		if err != nil {
+			return report, errors.Wrap(err, fmt.Sprintf("failed to list accounts in wallet %s", walletID))
+		}
+		for _, key := range accountIDs {
+			accountID, err := uuid.Parse(key)
+			if err != nil {
+				continue
+			}
+			if options.dryRun {
+				report.AccountsMigrated++
+				continue
+			}
+			if err := s.moveAccount(ctx, walletID, accountID, previousLayout); err != nil {
+				return report, err
+			}
+			report.AccountsMigrated++
+		}
+	}
+
+	return report, nil
+}
+
+// moveAccount moves an account from the given layout to the layout of the store.
+func (s *Store) moveAccount(ctx context.Context, walletID uuid.UUID, accountID uuid.UUID, from string) error {
+	fromPath := s.accountPathInLayout(walletID, accountID, from)
+	toPath := s.accountPath(walletID, accountID)
+	binding := accountBinding(walletID, accountID)
+
+	secret, err := s.kvGet(ctx, fromPath)
+	s.audit(ctx, AuditRead, binding, err)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to read account %s in wallet %s", accountID, walletID))
+	}
+	if existing, err := s.kvGet(ctx, toPath); err == nil {
+		// Already copied by an earlier, interrupted, migration.
+		if !reflect.DeepEqual(existing, secret) {
+			return fmt.Errorf("account %s in wallet %s already exists with different data", accountID, walletID)
+		}
+	} else {
+		err := s.kvPut(ctx, toPath, secret)
+		s.audit(ctx, AuditWrite, binding, err)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to write account %s in wallet %s", accountID, walletID))
+		}
+		stored, err := s.kvGet(ctx, toPath)
+		if err != nil || !reflect.DeepEqual(stored, secret) {
+			return fmt.Errorf("verification of account %s in wallet %s failed", accountID, walletID)
+		}
+	}
+	if s.custom_metadata {
+		customMetadata, err := s.kvGetCustomMetadata(ctx, fromPath)
+		if err == nil && len(customMetadata) > 0 {
+			if err := s.kvPutCustomMetadata(ctx, toPath, customMetadata); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("failed to write custom metadata for account %s in wallet %s", accountID, walletID))
+			}
+		}
+	}
+	err = s.kvDelete(ctx, fromPath)
+	s.audit(ctx, AuditDelete, binding, err)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to remove account %s in wallet %s from previous layout", accountID, walletID))
+	}
+	return nil
+}
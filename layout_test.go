@@ -0,0 +1,136 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidAccountLayout(t *testing.T) {
+	_, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithAccountLayout("nested"),
+	)
+	require.NotNil(t, err)
+	assert.Equal(t, `account_layout option must be "flat" or "sharded"`, err.Error())
+}
+
+func TestShardedLayout(t *testing.T) {
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	newStore := func(layout string) *vault.Store {
+		store, err := vault.New(
+			vault.WithPassphrase([]byte("test")),
+			vault.WithVaultAddr("http://localhost:8200"),
+			vault.WithVaultSecretMountPath(mount),
+			vault.WithVaultToken("golang-test"),
+			vault.WithVaultAuth("token"),
+			vault.WithAccountLayout(layout),
+		)
+		require.Nil(t, err)
+		return store.(*vault.Store)
+	}
+
+	flat := newStore(vault.AccountLayoutFlat)
+	walletID := uuid.New()
+	require.Nil(t, flat.StoreWallet(walletID, "layout wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"layout wallet"}`, walletID))))
+	require.Nil(t, flat.StoreAccountsIndex(walletID, []byte(`{"index":"test index data"}`)))
+	accounts := make(map[uuid.UUID][]byte)
+	for i := 0; i < 5; i++ {
+		accountID := uuid.New()
+		accounts[accountID] = []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d"}`, accountID, i))
+		require.Nil(t, flat.StoreAccount(walletID, accountID, accounts[accountID]))
+	}
+
+	// Accounts in the flat layout are not visible to a sharded store until migrated.
+	sharded := newStore(vault.AccountLayoutSharded)
+	assert.Len(t, drain(sharded.RetrieveAccounts(walletID)), 0)
+
+	report, err := sharded.MigrateAccountLayout(vault.WithMigrationDryRun())
+	require.Nil(t, err)
+	assert.Equal(t, 5, report.AccountsMigrated)
+	assert.Len(t, drain(sharded.RetrieveAccounts(walletID)), 0)
+
+	report, err = sharded.MigrateAccountLayout()
+	require.Nil(t, err)
+	assert.Equal(t, 5, report.AccountsMigrated)
+	assert.Len(t, drain(flat.RetrieveAccounts(walletID)), 0)
+	assert.Len(t, drain(sharded.RetrieveAccounts(walletID)), 5)
+	for accountID, accountData := range accounts {
+		data, err := sharded.RetrieveAccount(walletID, accountID)
+		require.Nil(t, err)
+		assert.Equal(t, accountData, data)
+	}
+	page, err := sharded.ListAccounts(context.Background(), walletID, "", 10)
+	require.Nil(t, err)
+	assert.Len(t, page.Items, 5)
+
+	// Nothing left to migrate.
+	report, err = sharded.MigrateAccountLayout()
+	require.Nil(t, err)
+	assert.Equal(t, 0, report.AccountsMigrated)
+
+	// The wallet cannot be deleted while it has sharded accounts.
+	assert.NotNil(t, sharded.DeleteWallet(walletID))
+}
+
+func TestMigrateAccountLayoutUndecryptable(t *testing.T) {
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	newStore := func(layout string, passphrase string) *vault.Store {
+		store, err := vault.New(
+			vault.WithPassphrase([]byte(passphrase)),
+			vault.WithVaultAddr("http://localhost:8200"),
+			vault.WithVaultSecretMountPath(mount),
+			vault.WithVaultToken("golang-test"),
+			vault.WithVaultAuth("token"),
+			vault.WithAccountLayout(layout),
+		)
+		require.Nil(t, err)
+		return store.(*vault.Store)
+	}
+
+	flat := newStore(vault.AccountLayoutFlat, "other")
+	walletID := uuid.New()
+	require.Nil(t, flat.StoreWallet(walletID, "layout wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"layout wallet"}`, walletID))))
+	for i := 0; i < 2; i++ {
+		accountID := uuid.New()
+		require.Nil(t, flat.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d"}`, accountID, i))))
+	}
+
+	// The migrating store cannot decrypt the wallet, but still moves its accounts.
+	report, err := newStore(vault.AccountLayoutSharded, "test").MigrateAccountLayout()
+	require.Nil(t, err)
+	assert.Equal(t, 2, report.AccountsMigrated)
+	assert.Len(t, drain(newStore(vault.AccountLayoutSharded, "other").RetrieveAccounts(walletID)), 2)
+}
+
+// drain reads all data from a channel.
+func drain(ch <-chan []byte) [][]byte {
+	res := make([][]byte, 0)
+	for data := range ch {
+		res = append(res, data)
+	}
+	return res
+}
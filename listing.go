@@ -0,0 +1,168 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Page is a page of wallet- or account-level data.
+type Page struct {
+	// Items are the wallet- or account-level data in this page.
+	Items [][]byte
+	// Cursor is passed to obtain the next page.  It is empty if there are no further pages.
+	Cursor string
+}
+
+// ListWallets retrieves a page of at most limit wallets, in order of wallet ID.
+// An empty cursor starts from the first wallet; otherwise it is the cursor of the previous page.
+// Vault cannot list from a cursor, so each page lists every wallet ID before retrieving its own wallets: paging
+// through N wallets makes N/limit full listings, and limit should be large for stores with many wallets.
+func (s *Store) ListWallets(ctx context.Context, cursor string, limit int) (_ *Page, err error) {
+	ctx, op := s.startOperation(ctx, "list_wallets")
+	defer op.end(&err)
+	walletIDs, err := s.listWalletIDs(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list wallets")
+	}
+	return s.listPage(ctx, walletIDs, cursor, limit, s.walletKeyPath)
+}
+
+// ListAccounts retrieves a page of at most limit accounts of a wallet, in order of account ID.
+// An empty cursor starts from the first account; otherwise it is the cursor of the previous page.
+// As with ListWallets, each page lists every account ID of the wallet, across all shards with the sharded layout,
+// before retrieving its own accounts.
+func (s *Store) ListAccounts(ctx context.Context, walletID uuid.UUID, cursor string, limit int) (_ *Page, err error) {
+	ctx, op := s.startOperation(ctx, "list_accounts")
+	defer op.end(&err)
+	accountIDs, err := s.listAccountIDs(ctx, walletID, s.account_layout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list accounts")
+	}
+	return s.listPage(ctx, accountIDs, cursor, limit, s.accountKeyPath(walletID))
+}
+
+// listWalletIDs lists the IDs of all wallets.
+func (s *Store) listWalletIDs(ctx context.Context) ([]string, error) {
+	keys, err := s.kvList(ctx, "wallets")
+	if err != nil {
+		return nil, err
+	}
+	walletIDs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			// Not a wallet directory.
+			continue
+		}
+		walletIDs = append(walletIDs, strings.TrimSuffix(key, "/"))
+	}
+	return walletIDs, nil
+}
+
+// walletKeyPath returns the path and binding of the wallet with the listed key.
+func (s *Store) walletKeyPath(key string) (string, *secretBinding, bool) {
+	walletID, err := uuid.Parse(key)
+	if err != nil {
+		return "", nil, false
+	}
+	return s.walletHeaderPath(walletID), walletBinding(walletID), true
+}
+
+// accountKeyPath returns a function providing the path and binding of the account in the given wallet with the
+// listed key.
+func (s *Store) accountKeyPath(walletID uuid.UUID) func(string) (string, *secretBinding, bool) {
+	return func(key string) (string, *secretBinding, bool) {
+		accountID, err := uuid.Parse(key)
+		if err != nil {
+			return "", nil, false
+		}
+		return s.accountPath(walletID, accountID), accountBinding(walletID, accountID), true
+	}
+}
+
+// listPage retrieves the data for a page of keys following the cursor.
+// Keys that cannot be retrieved or decrypted are skipped, as they are when retrieving all wallets or accounts, unless
+// retrieval is strict.
+func (s *Store) listPage(ctx context.Context, keys []string, cursor string, limit int, path func(string) (string, *secretBinding, bool)) (*Page, error) {
+	page := &Page{}
+	var err error
+	page.Cursor, err = walkPage(ctx, keys, cursor, limit, func(key string) (bool, error) {
+		secretPath, binding, valid := path(key)
+		if !valid {
+			return false, nil
+		}
+		secret, err := s.kvGet(ctx, secretPath)
+		if err != nil {
+			s.audit(ctx, AuditRead, binding, err)
+			if s.strict_retrieval {
+				return false, errors.Wrap(err, fmt.Sprintf("failed to retrieve %s", binding))
+			}
+			s.logger.Warn("skipped "+binding.kind+" that could not be retrieved", secretFields(binding, err)...)
+			return false, nil
+		}
+		data, err := s.openSecret(secret, binding)
+		s.audit(ctx, AuditRead, binding, err)
+		if err != nil {
+			if s.strict_retrieval {
+				return false, errors.Wrap(err, fmt.Sprintf("failed to decrypt %s", binding))
+			}
+			s.logger.Warn("skipped "+binding.kind+" that could not be decrypted", secretFields(binding, err)...)
+			return false, nil
+		}
+		page.Items = append(page.Items, data)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// walkPage visits the keys following the cursor, in order, until limit of them have been accepted by visit.
+// It returns the cursor for the following page, which is empty if all keys have been visited, or the first error
+// returned by visit.
+func walkPage(ctx context.Context, keys []string, cursor string, limit int, visit func(string) (bool, error)) (string, error) {
+	if limit <= 0 {
+		return "", errors.New("limit must be positive")
+	}
+	sort.Strings(keys)
+	start := sort.SearchStrings(keys, cursor)
+	if start < len(keys) && keys[start] == cursor {
+		start++
+	}
+
+	accepted := 0
+	for i := start; i < len(keys); i++ {
+		if accepted == limit {
+			return keys[i-1], nil
+		}
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		visited, err := visit(keys[i])
+		if err != nil {
+			return "", err
+		}
+		if visited {
+			accepted++
+		}
+	}
+	return "", nil
+}
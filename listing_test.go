@@ -0,0 +1,89 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListWallets(t *testing.T) {
+	ctx := context.Background()
+	store := newFreshMountStore(t)
+
+	expected := make([]string, 5)
+	for i := range expected {
+		walletID := uuid.New()
+		expected[i] = fmt.Sprintf(`{"uuid":%q,"name":"list wallet %d"}`, walletID, i)
+		require.Nil(t, store.StoreWallet(walletID, fmt.Sprintf("list wallet %d", i), []byte(expected[i])))
+	}
+	sort.Strings(expected)
+
+	_, err := store.ListWallets(ctx, "", 0)
+	require.NotNil(t, err)
+	assert.Equal(t, "limit must be positive", err.Error())
+
+	listed := make([]string, 0)
+	pages := 0
+	cursor := ""
+	for {
+		page, err := store.ListWallets(ctx, cursor, 2)
+		require.Nil(t, err)
+		pages++
+		assert.LessOrEqual(t, len(page.Items), 2)
+		for _, item := range page.Items {
+			listed = append(listed, string(item))
+		}
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, expected, listed)
+}
+
+func TestListAccounts(t *testing.T) {
+	ctx := context.Background()
+	store := newFreshMountStore(t)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "list wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"list wallet"}`, walletID))))
+	require.Nil(t, store.StoreAccountsIndex(walletID, []byte(`{"index":"test index data"}`)))
+	accountIDs := make([]string, 3)
+	for i := range accountIDs {
+		accountID := uuid.New()
+		accountIDs[i] = accountID.String()
+		require.Nil(t, store.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d"}`, accountID, i))))
+	}
+	sort.Strings(accountIDs)
+
+	page, err := store.ListAccounts(ctx, walletID, "", 2)
+	require.Nil(t, err)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, accountIDs[1], page.Cursor)
+
+	// Resume from the cursor.
+	page, err = store.ListAccounts(ctx, walletID, page.Cursor, 2)
+	require.Nil(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Contains(t, string(page.Items[0]), accountIDs[2])
+	assert.Equal(t, "", page.Cursor)
+}
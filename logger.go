@@ -0,0 +1,131 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Logger receives the store's log messages, each with alternating keys and values.  Implementations must be safe
+// for concurrent use.  A *slog.Logger satisfies this interface, and adapters for slog and zerolog are provided in the
+// logger/slog and logger/zerolog packages.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// stdLogger writes warnings and errors with the standard log package.
+type stdLogger struct{}
+
+func (stdLogger) Debug(string, ...interface{}) {}
+func (stdLogger) Info(string, ...interface{})  {}
+
+func (stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	log.Print(formatLog("warn", msg, keysAndValues))
+}
+
+func (stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	log.Print(formatLog("error", msg, keysAndValues))
+}
+
+// formatLog formats a log message as a single line.
+func formatLog(level string, msg string, keysAndValues []interface{}) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s: %s", level, msg)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fmt.Fprintf(&builder, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	return builder.String()
+}
+
+// vaultTokenRegexp matches Vault service, batch and recovery tokens.
+var vaultTokenRegexp = regexp.MustCompile(`\b(hv[sbr]\.[A-Za-z0-9_-]{20,}|[sbr]\.[A-Za-z0-9]{24})\b`)
+
+// uuidRegexp matches wallet and account IDs.
+var uuidRegexp = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// redactingLogger removes Vault tokens from the values it logs and, if configured, wallet and account IDs, and so the
+// paths containing them.
+type redactingLogger struct {
+	logger Logger
+	redact bool
+}
+
+func (l *redactingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Debug(msg, l.values(keysAndValues)...)
+}
+
+func (l *redactingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Info(msg, l.values(keysAndValues)...)
+}
+
+func (l *redactingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warn(msg, l.values(keysAndValues)...)
+}
+
+func (l *redactingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Error(msg, l.values(keysAndValues)...)
+}
+
+// values returns the redacted keys and values.  Errors are logged as their messages.
+func (l *redactingLogger) values(keysAndValues []interface{}) []interface{} {
+	res := make([]interface{}, len(keysAndValues))
+	for i, value := range keysAndValues {
+		switch v := value.(type) {
+		case uuid.UUID:
+			if l.redact {
+				res[i] = "{id}"
+			} else {
+				res[i] = v.String()
+			}
+		case error:
+			res[i] = l.redactString(v.Error())
+		case string:
+			res[i] = l.redactString(v)
+		default:
+			res[i] = value
+		}
+	}
+	return res
+}
+
+func (l *redactingLogger) redactString(value string) string {
+	value = vaultTokenRegexp.ReplaceAllString(value, "[redacted]")
+	if l.redact {
+		value = uuidRegexp.ReplaceAllString(value, "{id}")
+	}
+	return value
+}
+
+// secretFields returns the log fields identifying a secret, followed by the error encountered with it, if any.
+func secretFields(binding *secretBinding, err error) []interface{} {
+	keysAndValues := make([]interface{}, 0, 6)
+	if binding.walletID != uuid.Nil {
+		keysAndValues = append(keysAndValues, "wallet", binding.walletID)
+	}
+	if binding.accountID != uuid.Nil {
+		keysAndValues = append(keysAndValues, "account", binding.accountID)
+	}
+	if err == nil {
+		return keysAndValues
+	}
+	return append(keysAndValues, "error", err)
+}
@@ -0,0 +1,67 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package slog passes a Vault store's log messages to a slog logger.
+// A *slog.Logger can also be given to the store directly; this additionally passes a context to the logger's handler.
+package slog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger passes a Vault store's log messages to a slog logger.  It implements vaultstorage.Logger.
+type Logger struct {
+	logger *slog.Logger
+	ctx    context.Context
+}
+
+// New creates a logger that passes messages to the given slog logger, or to the default slog logger if it is nil.
+func New(logger *slog.Logger) *Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Logger{
+		logger: logger,
+		ctx:    context.Background(),
+	}
+}
+
+// WithContext returns a logger that passes the given context to the slog logger's handler, for example to add
+// trace IDs.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	return &Logger{
+		logger: l.logger,
+		ctx:    ctx,
+	}
+}
+
+// Debug logs a message at debug level.
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(l.ctx, slog.LevelDebug, msg, keysAndValues...)
+}
+
+// Info logs a message at info level.
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(l.ctx, slog.LevelInfo, msg, keysAndValues...)
+}
+
+// Warn logs a message at warn level.
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(l.ctx, slog.LevelWarn, msg, keysAndValues...)
+}
+
+// Error logs a message at error level.
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(l.ctx, slog.LevelError, msg, keysAndValues...)
+}
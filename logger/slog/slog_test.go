@@ -0,0 +1,47 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	vaultslog "github.com/stake-capital/go-eth2-wallet-store-vault/logger/slog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := vaultslog.New(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	var _ vault.Logger = logger
+	// A slog logger can also be used directly.
+	var _ vault.Logger = slog.Default()
+
+	logger.Debug("not logged")
+	logger.Warn("retried Vault request", "request", "read", "retries", 2)
+
+	entry := make(map[string]interface{})
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	delete(entry, "time")
+	assert.Equal(t, map[string]interface{}{
+		"level":   "WARN",
+		"msg":     "retried Vault request",
+		"request": "read",
+		"retries": float64(2),
+	}, entry)
+}
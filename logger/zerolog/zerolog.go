@@ -0,0 +1,72 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zerolog passes a Vault store's log messages to a zerolog logger.
+package zerolog
+
+import (
+	"fmt"
+	"time"
+
+	zl "github.com/rs/zerolog"
+)
+
+// Logger passes a Vault store's log messages to a zerolog logger.  It implements vaultstorage.Logger.
+type Logger struct {
+	logger zl.Logger
+}
+
+// New creates a logger that passes messages to the given zerolog logger.
+func New(logger zl.Logger) *Logger {
+	return &Logger{
+		logger: logger,
+	}
+}
+
+// Debug logs a message at debug level.
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	log(l.logger.Debug(), msg, keysAndValues)
+}
+
+// Info logs a message at info level.
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	log(l.logger.Info(), msg, keysAndValues)
+}
+
+// Warn logs a message at warn level.
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	log(l.logger.Warn(), msg, keysAndValues)
+}
+
+// Error logs a message at error level.
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	log(l.logger.Error(), msg, keysAndValues)
+}
+
+// log adds the keys and values to an event and sends it.  Events for disabled levels are nil and ignore both.
+func log(event *zl.Event, msg string, keysAndValues []interface{}) {
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		switch value := keysAndValues[i+1].(type) {
+		case string:
+			event = event.Str(key, value)
+		case error:
+			event = event.AnErr(key, value)
+		case time.Duration:
+			event = event.Dur(key, value)
+		default:
+			event = event.Interface(key, value)
+		}
+	}
+	event.Msg(msg)
+}
@@ -0,0 +1,47 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zerolog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	zl "github.com/rs/zerolog"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stake-capital/go-eth2-wallet-store-vault/logger/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(zl.New(&buf).Level(zl.InfoLevel))
+	var _ vault.Logger = logger
+
+	logger.Debug("not logged")
+	logger.Warn("failed to log in to Vault", "error", errors.New("connection refused"), "retry_in", 10*time.Second, "renewable", true)
+
+	entry := make(map[string]interface{})
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, map[string]interface{}{
+		"level":     "warn",
+		"message":   "failed to log in to Vault",
+		"error":     "connection refused",
+		"retry_in":  float64(10000),
+		"renewable": true,
+	}, entry)
+}
@@ -0,0 +1,132 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logEntry is a message received by recordingLogger.
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// recordingLogger records the messages it receives.
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []*logEntry
+}
+
+func (l *recordingLogger) record(level string, msg string, keysAndValues []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := &logEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		entry.fields[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	l.entries = append(l.entries, entry)
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.record("debug", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.record("info", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.record("warn", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.record("error", msg, keysAndValues)
+}
+
+// find returns the entries with the given message.
+func (l *recordingLogger) find(msg string) []*logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make([]*logEntry, 0)
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+func TestLogger(t *testing.T) {
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	newStore := func(opts ...vault.Option) *vault.Store {
+		store, err := vault.New(append([]vault.Option{
+			vault.WithVaultAddr("http://localhost:8200"),
+			vault.WithVaultSecretMountPath(mount),
+			vault.WithVaultToken("golang-test"),
+			vault.WithVaultAuth("token"),
+		}, opts...)...)
+		require.Nil(t, err)
+		return store.(*vault.Store)
+	}
+
+	store := newStore(vault.WithPassphrase([]byte("test")))
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "logger wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"logger wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"logger account"}`, accountID))))
+
+	// Secrets that cannot be decrypted are logged as they are skipped.
+	logger := &recordingLogger{}
+	store = newStore(vault.WithPassphrase([]byte("other")), vault.WithLogger(logger))
+	require.Len(t, logger.find("logged in to Vault"), 1)
+	assert.Equal(t, "info", logger.find("logged in to Vault")[0].level)
+	assert.Equal(t, "token", logger.find("logged in to Vault")[0].fields["auth"])
+	for range store.RetrieveWallets() {
+	}
+	for range store.RetrieveAccounts(walletID) {
+	}
+	wallets := logger.find("skipped wallet that could not be decrypted")
+	require.Len(t, wallets, 1)
+	assert.Equal(t, "warn", wallets[0].level)
+	assert.Equal(t, walletID.String(), wallets[0].fields["wallet"])
+	assert.NotEmpty(t, wallets[0].fields["error"])
+	accounts := logger.find("skipped account that could not be decrypted")
+	require.Len(t, accounts, 1)
+	assert.Equal(t, walletID.String(), accounts[0].fields["wallet"])
+	assert.Equal(t, accountID.String(), accounts[0].fields["account"])
+
+	// Redaction removes wallet and account IDs.
+	logger = &recordingLogger{}
+	store = newStore(vault.WithPassphrase([]byte("other")), vault.WithLogger(logger), vault.WithLogRedaction())
+	for range store.RetrieveAccounts(walletID) {
+	}
+	accounts = logger.find("skipped account that could not be decrypted")
+	require.Len(t, accounts, 1)
+	assert.Equal(t, "{id}", accounts[0].fields["wallet"])
+	assert.Equal(t, "{id}", accounts[0].fields["account"])
+	assert.NotContains(t, accounts[0].fields["error"], walletID.String())
+
+	require.Nil(t, store.Close())
+	require.Len(t, logger.find("closed store"), 1)
+}
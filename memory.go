@@ -0,0 +1,68 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"github.com/pkg/errors"
+)
+
+// errClosed is returned when using a store after it has been closed.
+var errClosed = errors.New("store is closed")
+
+// zero overwrites a buffer that held sensitive data.
+func zero(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
+
+// copyPassphrase returns the store's own copy of the passphrase, locked in to memory if required, along with a
+// function that wipes and releases it.
+func copyPassphrase(passphrase []byte, lock bool) ([]byte, func() error, error) {
+	if lock && len(passphrase) > 0 {
+		return lockedCopy(passphrase)
+	}
+	res := make([]byte, len(passphrase))
+	copy(res, passphrase)
+	return res, func() error {
+		zero(res)
+		return nil
+	}, nil
+}
+
+// Close wipes the store's copy of its passphrase, clears its Vault token and stops renewing it.  The store cannot
+// be used once closed.  Data returned by the store is not affected; callers should wipe it once no longer required.
+// Closing a closed store has no effect.
+func (s *Store) Close() error {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	// The token is cleared once it can no longer be replaced by logging in again.
+	s.maintenance.Wait()
+	s.clearToken()
+	if err := s.releasePassphrase(); err != nil {
+		return errors.Wrap(err, "failed to release passphrase")
+	}
+	if s.auditFile != nil {
+		if err := s.auditFile.Close(); err != nil {
+			return errors.Wrap(err, "failed to close audit file")
+		}
+	}
+	s.logger.Debug("closed store")
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package vaultstorage
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// lockedCopy returns a copy of data in its own pages, locked in to memory so that it is never swapped and excluded
+// from core dumps, along with a function that wipes and releases it.
+func lockedCopy(data []byte) ([]byte, func() error, error) {
+	pageSize := os.Getpagesize()
+	size := (len(data) + pageSize - 1) / pageSize * pageSize
+	mem, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to allocate locked memory")
+	}
+	if err := unix.Mlock(mem); err != nil {
+		_ = unix.Munmap(mem)
+		return nil, nil, errors.Wrap(err, "failed to lock memory")
+	}
+	// Excluding from core dumps is best effort, as older kernels do not support it.
+	_ = unix.Madvise(mem, unix.MADV_DONTDUMP)
+	copy(mem, data)
+
+	return mem[:len(data)], func() error {
+		zero(mem)
+		if err := unix.Munlock(mem); err != nil {
+			return err
+		}
+		return unix.Munmap(mem)
+	}, nil
+}
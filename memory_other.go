@@ -0,0 +1,26 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package vaultstorage
+
+import (
+	"github.com/pkg/errors"
+)
+
+// lockedCopy is not supported on this platform.
+func lockedCopy(_ []byte) ([]byte, func() error, error) {
+	return nil, nil, errors.New("lock_memory option is only supported on Linux")
+}
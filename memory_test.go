@@ -0,0 +1,85 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClose(t *testing.T) {
+	passphrase := []byte("test")
+	store, err := vault.New(
+		vault.WithPassphrase(passphrase),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+	vaultStore := store.(*vault.Store)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "close wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"close wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"close account"}`, accountID))))
+
+	require.Nil(t, vaultStore.Close())
+	// The store holds its own copy of the passphrase, so the caller's is untouched.
+	assert.Equal(t, []byte("test"), passphrase)
+
+	_, err = store.RetrieveAccount(walletID, accountID)
+	assert.NotNil(t, err)
+	err = store.StoreWallet(walletID, "close wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"close wallet"}`, walletID)))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "store is closed")
+
+	// Closing again has no effect.
+	require.Nil(t, vaultStore.Close())
+}
+
+func TestLockedMemory(t *testing.T) {
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithLockedMemory(),
+	)
+	if runtime.GOOS != "linux" {
+		require.NotNil(t, err)
+		assert.Equal(t, "lock_memory option is only supported on Linux", err.Error())
+		return
+	}
+	require.Nil(t, err)
+
+	walletID := uuid.New()
+	data := []byte(fmt.Sprintf(`{"uuid":%q,"name":"locked wallet"}`, walletID))
+	require.Nil(t, store.StoreWallet(walletID, "locked wallet", data))
+	accountID := uuid.New()
+	accountData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"locked account"}`, accountID))
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData))
+	retData, err := store.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, accountData, retData)
+
+	require.Nil(t, store.(*vault.Store).Close())
+}
@@ -0,0 +1,183 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// storeVersion is the version of the store's secret layout and encoding, recorded in custom metadata.
+const storeVersion = "1"
+
+// Metadata is the non-secret metadata of a wallet or account, as held in KV version 2 custom metadata.
+type Metadata struct {
+	// Type is "wallet", "account" or "index".
+	Type        string
+	WalletID    uuid.UUID
+	WalletName  string
+	AccountID   uuid.UUID
+	AccountName string
+	// PubKey is the hex-encoded public key of an account, if known.
+	PubKey string
+	// CreatedBy is the hex-encoded ID of the store that wrote the secret, if it has one.
+	CreatedBy    string
+	StoreVersion string
+}
+
+// MetadataPage is a page of wallet or account metadata.
+type MetadataPage struct {
+	// Items are the metadata in this page.
+	Items []*Metadata
+	// Cursor is passed to obtain the next page.  It is empty if there are no further pages.
+	Cursor string
+}
+
+// ListWalletMetadata retrieves the metadata of a page of at most limit wallets, in order of wallet ID, without
+// fetching their data.  Wallets without custom metadata are skipped.  It requires KV version 2.
+// An empty cursor starts from the first wallet; otherwise it is the cursor of the previous page.  As with
+// ListWallets, each page lists every wallet ID.
+func (s *Store) ListWalletMetadata(ctx context.Context, cursor string, limit int) (_ *MetadataPage, err error) {
+	ctx, op := s.startOperation(ctx, "list_wallet_metadata")
+	defer op.end(&err)
+	if s.vault_kv_version != 2 {
+		return nil, errors.New("metadata listing requires KV version 2")
+	}
+	walletIDs, err := s.listWalletIDs(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list wallets")
+	}
+	return s.listMetadataPage(ctx, walletIDs, cursor, limit, s.walletKeyPath)
+}
+
+// ListAccountMetadata retrieves the metadata of a page of at most limit accounts of a wallet, in order of account ID,
+// without fetching their data.  Accounts without custom metadata are skipped.  It requires KV version 2.
+// An empty cursor starts from the first account; otherwise it is the cursor of the previous page.  As with
+// ListAccounts, each page lists every account ID of the wallet.
+func (s *Store) ListAccountMetadata(ctx context.Context, walletID uuid.UUID, cursor string, limit int) (_ *MetadataPage, err error) {
+	ctx, op := s.startOperation(ctx, "list_account_metadata")
+	defer op.end(&err)
+	if s.vault_kv_version != 2 {
+		return nil, errors.New("metadata listing requires KV version 2")
+	}
+	accountIDs, err := s.listAccountIDs(ctx, walletID, s.account_layout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list accounts")
+	}
+	return s.listMetadataPage(ctx, accountIDs, cursor, limit, s.accountKeyPath(walletID))
+}
+
+// listMetadataPage retrieves the metadata for a page of keys following the cursor.
+func (s *Store) listMetadataPage(ctx context.Context, keys []string, cursor string, limit int, path func(string) (string, *secretBinding, bool)) (*MetadataPage, error) {
+	page := &MetadataPage{}
+	var err error
+	page.Cursor, err = walkPage(ctx, keys, cursor, limit, func(key string) (bool, error) {
+		secretPath, _, valid := path(key)
+		if !valid {
+			return false, nil
+		}
+		customMetadata, err := s.kvGetCustomMetadata(ctx, secretPath)
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			// Removed since listing.
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, fmt.Sprintf("failed to retrieve metadata for %s", key))
+		}
+		if len(customMetadata) == 0 {
+			// Written without metadata.
+			return false, nil
+		}
+		page.Items = append(page.Items, metadataFromCustom(customMetadata))
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// storeCustomMetadata writes metadata to the custom metadata of the secret at the given path, if configured.
+func (s *Store) storeCustomMetadata(ctx context.Context, path string, metadata *Metadata) error {
+	if !s.custom_metadata {
+		return nil
+	}
+	metadata.CreatedBy = fmt.Sprintf("%x", s.id)
+	metadata.StoreVersion = storeVersion
+	if err := s.kvPutCustomMetadata(ctx, path, metadata.custom()); err != nil {
+		return errors.Wrap(err, "failed to store custom metadata")
+	}
+	return nil
+}
+
+// accountMetadata returns the metadata of an account given its wallet- and account-level data.
+func accountMetadata(walletData []byte, accountID uuid.UUID, accountData []byte) *Metadata {
+	wallet := &migrationInfo{}
+	// Metadata is informational, so missing fields are left empty rather than failing.
+	_ = json.Unmarshal(walletData, wallet)
+	account := &migrationInfo{}
+	_ = json.Unmarshal(accountData, account)
+	return &Metadata{
+		Type:        "account",
+		WalletID:    wallet.ID,
+		WalletName:  wallet.Name,
+		AccountID:   accountID,
+		AccountName: account.Name,
+		PubKey:      accountPublicKey(accountData),
+	}
+}
+
+// custom returns the metadata as KV version 2 custom metadata, omitting empty values.
+func (m *Metadata) custom() map[string]string {
+	res := make(map[string]string)
+	set := func(key string, value string) {
+		if value != "" {
+			res[key] = value
+		}
+	}
+	set("type", m.Type)
+	if m.WalletID != uuid.Nil {
+		set("wallet_id", m.WalletID.String())
+	}
+	set("wallet_name", m.WalletName)
+	if m.AccountID != uuid.Nil {
+		set("account_id", m.AccountID.String())
+	}
+	set("account_name", m.AccountName)
+	set("pubkey", m.PubKey)
+	set("created_by", m.CreatedBy)
+	set("store_version", m.StoreVersion)
+	return res
+}
+
+// metadataFromCustom returns metadata from KV version 2 custom metadata.
+func metadataFromCustom(custom map[string]string) *Metadata {
+	walletID, _ := uuid.Parse(custom["wallet_id"])
+	accountID, _ := uuid.Parse(custom["account_id"])
+	return &Metadata{
+		Type:         custom["type"],
+		WalletID:     walletID,
+		WalletName:   custom["wallet_name"],
+		AccountID:    accountID,
+		AccountName:  custom["account_name"],
+		PubKey:       custom["pubkey"],
+		CreatedBy:    custom["created_by"],
+		StoreVersion: custom["store_version"],
+	}
+}
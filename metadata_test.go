@@ -0,0 +1,126 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	vaultapi "github.com/hashicorp/vault/api"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomMetadata(t *testing.T) {
+	ctx := context.Background()
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKV(t, mount, "2")
+	store, err := vault.New(
+		vault.WithID([]byte("metadata test")),
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath(mount),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithCustomMetadata(),
+	)
+	require.Nil(t, err)
+	vaultStore := store.(*vault.Store)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "metadata wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"metadata wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"metadata account","pubkey":"a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c","crypto":{"secret":"value"}}`, accountID))))
+
+	walletPage, err := vaultStore.ListWalletMetadata(ctx, "", 10)
+	require.Nil(t, err)
+	assert.Equal(t, "", walletPage.Cursor)
+	assert.Equal(t, []*vault.Metadata{
+		{
+			Type:         "wallet",
+			WalletID:     walletID,
+			WalletName:   "metadata wallet",
+			CreatedBy:    fmt.Sprintf("%x", "metadata test"),
+			StoreVersion: "1",
+		},
+	}, walletPage.Items)
+
+	accountPage, err := vaultStore.ListAccountMetadata(ctx, walletID, "", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []*vault.Metadata{
+		{
+			Type:         "account",
+			WalletID:     walletID,
+			WalletName:   "metadata wallet",
+			AccountID:    accountID,
+			AccountName:  "metadata account",
+			PubKey:       "a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c",
+			CreatedBy:    fmt.Sprintf("%x", "metadata test"),
+			StoreVersion: "1",
+		},
+	}, accountPage.Items)
+
+	// Metadata is visible to a token that can read metadata but not data.
+	token := createPolicyToken(t, "wallets-metadata-only", fmt.Sprintf(`
+path "%s/metadata/wallets/*" {
+  capabilities = ["read"]
+}
+`, mount))
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken(token)
+	metadata, err := client.KVv2(mount).GetMetadata(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID))
+	require.Nil(t, err)
+	assert.Equal(t, "metadata account", metadata.CustomMetadata["account_name"])
+	_, err = client.KVv2(mount).Get(ctx, fmt.Sprintf("wallets/%s/%s", walletID, accountID))
+	assert.NotNil(t, err)
+
+	// Metadata that cannot be read is an error rather than an omission.
+	token = createPolicyToken(t, "wallets-metadata-list-only", fmt.Sprintf(`
+path "%s/metadata/wallets*" {
+  capabilities = ["list"]
+}
+`, mount))
+	listStore, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath(mount),
+		vault.WithVaultKVVersion(2),
+		vault.WithVaultToken(token),
+		vault.WithVaultAuth("token"),
+		vault.WithCustomMetadata(),
+	)
+	require.Nil(t, err)
+	_, err = listStore.(*vault.Store).ListWalletMetadata(ctx, "", 10)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to retrieve metadata")
+}
+
+func TestCustomMetadataKVv1(t *testing.T) {
+	mountKVv1(t, "kv1")
+	_, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("kv1"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithCustomMetadata(),
+	)
+	require.NotNil(t, err)
+	assert.Equal(t, "custom_metadata option requires KV version 2", err.Error())
+}
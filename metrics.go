@@ -0,0 +1,124 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Types of error reported to metrics.
+const (
+	// ErrorTypeNotFound is a wallet, account or index that does not exist.
+	ErrorTypeNotFound = "not_found"
+	// ErrorTypePermissionDenied is a request refused by Vault for lack of capabilities.
+	ErrorTypePermissionDenied = "permission_denied"
+	// ErrorTypeVault is any other error returned by Vault.
+	ErrorTypeVault = "vault"
+	// ErrorTypeUnavailable is a failure to reach Vault, or a Vault server error.
+	ErrorTypeUnavailable = "unavailable"
+	// ErrorTypeTimeout is a request that timed out or was cancelled.
+	ErrorTypeTimeout = "timeout"
+	// ErrorTypeDecode is a secret that could not be decoded or decrypted.
+	ErrorTypeDecode = "decode"
+	// ErrorTypeReadOnly is an attempt to write to a read-only store.
+	ErrorTypeReadOnly = "read_only"
+	// ErrorTypeClosed is use of a closed store.
+	ErrorTypeClosed = "closed"
+	// ErrorTypeOther is any other error.
+	ErrorTypeOther = "other"
+)
+
+// Metrics receives measurements of the store's operations.  Implementations must be safe for concurrent use.
+// A Prometheus implementation is provided in the metrics/prometheus package.
+type Metrics interface {
+	// ObserveOperation records the duration of a store operation, such as "retrieve_account", and the type of error
+	// it returned, which is empty on success.
+	ObserveOperation(operation string, duration time.Duration, errorType string)
+	// ObserveVaultRequest records the duration of a request to Vault, such as "read", and the type of error it
+	// returned, which is empty on success.
+	ObserveVaultRequest(request string, duration time.Duration, errorType string)
+	// CacheLookup records a lookup in one of the store's caches.  The only cache is the public key index, "pubkeys",
+	// which misses when an account is not in the index or its entry is stale.
+	CacheLookup(cache string, hit bool)
+	// TokenRenewal records an attempt to renew the store's Vault token.
+	TokenRenewal(success bool)
+	// Retry records the retry of a failed request, such as "login" or "read".
+	Retry(request string)
+}
+
+// nullMetrics discards measurements.
+type nullMetrics struct{}
+
+func (nullMetrics) ObserveOperation(string, time.Duration, string)    {}
+func (nullMetrics) ObserveVaultRequest(string, time.Duration, string) {}
+func (nullMetrics) CacheLookup(string, bool)                          {}
+func (nullMetrics) TokenRenewal(bool)                                 {}
+func (nullMetrics) Retry(string)                                      {}
+
+// decodeError is an error decoding or decrypting a secret.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+// errorType returns the type of an error as reported to metrics, or an empty string if there is no error.
+func errorType(err error) string {
+	if err == nil {
+		return ""
+	}
+	var readOnlyErr *ReadOnlyError
+	var decodeErr *decodeError
+	var responseErr *vault.ResponseError
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, errClosed):
+		return ErrorTypeClosed
+	case errors.As(err, &readOnlyErr):
+		return ErrorTypeReadOnly
+	case errors.As(err, &decodeErr):
+		return ErrorTypeDecode
+	case errors.Is(err, vault.ErrSecretNotFound), errors.Is(err, errWalletNotFound), errors.Is(err, errAccountNotFound):
+		return ErrorTypeNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return ErrorTypeTimeout
+	case errors.As(err, &responseErr):
+		switch {
+		case responseErr.StatusCode == http.StatusNotFound:
+			return ErrorTypeNotFound
+		case responseErr.StatusCode == http.StatusForbidden:
+			return ErrorTypePermissionDenied
+		case responseErr.StatusCode >= http.StatusInternalServerError:
+			return ErrorTypeUnavailable
+		default:
+			return ErrorTypeVault
+		}
+	case errors.As(err, &urlErr):
+		return ErrorTypeUnavailable
+	default:
+		return ErrorTypeOther
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prometheus records measurements of a Vault store's operations as Prometheus metrics.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// options are the options for the metrics.
+type options struct {
+	namespace  string
+	registerer prom.Registerer
+	buckets    []float64
+}
+
+// Option gives options to New
+type Option interface {
+	apply(*options)
+}
+
+type optionFunc func(*options)
+
+func (f optionFunc) apply(o *options) {
+	f(o)
+}
+
+// WithNamespace sets the namespace of the metrics.
+func WithNamespace(namespace string) Option {
+	return optionFunc(func(o *options) {
+		o.namespace = namespace
+	})
+}
+
+// WithRegisterer sets the registerer with which the metrics are registered.
+func WithRegisterer(registerer prom.Registerer) Option {
+	return optionFunc(func(o *options) {
+		o.registerer = registerer
+	})
+}
+
+// WithBuckets sets the buckets, in seconds, of the operation and request duration histograms.
+func WithBuckets(buckets []float64) Option {
+	return optionFunc(func(o *options) {
+		o.buckets = buckets
+	})
+}
+
+// Metrics records measurements of a Vault store as Prometheus metrics.  It implements vaultstorage.Metrics.
+type Metrics struct {
+	operationDuration *prom.HistogramVec
+	operationErrors   *prom.CounterVec
+	requestDuration   *prom.HistogramVec
+	requestErrors     *prom.CounterVec
+	cacheLookups      *prom.CounterVec
+	tokenRenewals     *prom.CounterVec
+	retries           *prom.CounterVec
+}
+
+// New creates Prometheus metrics for a Vault store.
+// This takes the following options:
+//   - namespace: the namespace of the metrics, defaults to "vault_store", set with WithNamespace()
+//   - registerer: the registerer with which the metrics are registered, defaults to the default registerer, set with WithRegisterer()
+//   - buckets: the buckets of the duration histograms, defaults to the Prometheus default buckets, set with WithBuckets()
+func New(opts ...Option) (*Metrics, error) {
+	options := options{
+		namespace:  "vault_store",
+		registerer: prom.DefaultRegisterer,
+		buckets:    prom.DefBuckets,
+	}
+	for _, o := range opts {
+		o.apply(&options)
+	}
+
+	m := &Metrics{
+		operationDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: options.namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "The duration of store operations.",
+			Buckets:   options.buckets,
+		}, []string{"operation"}),
+		operationErrors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: options.namespace,
+			Name:      "operation_errors_total",
+			Help:      "The number of store operations that failed, by type of error.",
+		}, []string{"operation", "type"}),
+		requestDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: options.namespace,
+			Name:      "vault_request_duration_seconds",
+			Help:      "The duration of requests to Vault.",
+			Buckets:   options.buckets,
+		}, []string{"request"}),
+		requestErrors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: options.namespace,
+			Name:      "vault_request_errors_total",
+			Help:      "The number of requests to Vault that failed, by type of error.",
+		}, []string{"request", "type"}),
+		cacheLookups: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: options.namespace,
+			Name:      "cache_lookups_total",
+			Help:      "The number of cache lookups, by result.",
+		}, []string{"cache", "result"}),
+		tokenRenewals: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: options.namespace,
+			Name:      "token_renewals_total",
+			Help:      "The number of attempts to renew the Vault token, by result.",
+		}, []string{"result"}),
+		retries: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: options.namespace,
+			Name:      "retries_total",
+			Help:      "The number of retried requests.",
+		}, []string{"request"}),
+	}
+
+	for _, collector := range []prom.Collector{
+		m.operationDuration,
+		m.operationErrors,
+		m.requestDuration,
+		m.requestErrors,
+		m.cacheLookups,
+		m.tokenRenewals,
+		m.retries,
+	} {
+		if err := options.registerer.Register(collector); err != nil {
+			return nil, errors.Wrap(err, "failed to register metric")
+		}
+	}
+
+	return m, nil
+}
+
+// ObserveOperation records the duration of a store operation and the type of error it returned.
+func (m *Metrics) ObserveOperation(operation string, duration time.Duration, errorType string) {
+	m.operationDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if errorType != "" {
+		m.operationErrors.WithLabelValues(operation, errorType).Inc()
+	}
+}
+
+// ObserveVaultRequest records the duration of a request to Vault and the type of error it returned.
+func (m *Metrics) ObserveVaultRequest(request string, duration time.Duration, errorType string) {
+	m.requestDuration.WithLabelValues(request).Observe(duration.Seconds())
+	if errorType != "" {
+		m.requestErrors.WithLabelValues(request, errorType).Inc()
+	}
+}
+
+// CacheLookup records a lookup in one of the store's caches.
+func (m *Metrics) CacheLookup(cache string, hit bool) {
+	m.cacheLookups.WithLabelValues(cache, result(hit, "hit", "miss")).Inc()
+}
+
+// TokenRenewal records an attempt to renew the store's Vault token.
+func (m *Metrics) TokenRenewal(success bool) {
+	m.tokenRenewals.WithLabelValues(result(success, "success", "failure")).Inc()
+}
+
+// Retry records the retry of a failed request.
+func (m *Metrics) Retry(request string) {
+	m.retries.WithLabelValues(request).Inc()
+}
+
+// result returns the label for a boolean result.
+func result(value bool, ifTrue string, ifFalse string) string {
+	if value {
+		return ifTrue
+	}
+	return ifFalse
+}
@@ -0,0 +1,69 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stake-capital/go-eth2-wallet-store-vault/metrics/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prom.NewRegistry()
+	metrics, err := prometheus.New(prometheus.WithRegisterer(registry), prometheus.WithNamespace("test"))
+	require.Nil(t, err)
+	var _ vault.Metrics = metrics
+
+	metrics.ObserveOperation("retrieve_account", time.Millisecond, "")
+	metrics.ObserveOperation("retrieve_account", time.Millisecond, vault.ErrorTypeNotFound)
+	metrics.ObserveVaultRequest("read", time.Millisecond, vault.ErrorTypeUnavailable)
+	metrics.CacheLookup("pubkeys", true)
+	metrics.CacheLookup("pubkeys", false)
+	metrics.CacheLookup("pubkeys", false)
+	metrics.TokenRenewal(true)
+	metrics.Retry("login")
+
+	count, err := testutil.GatherAndCount(registry, "test_operation_duration_seconds")
+	require.Nil(t, err)
+	assert.Equal(t, 1, count)
+	require.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP test_operation_errors_total The number of store operations that failed, by type of error.
+# TYPE test_operation_errors_total counter
+test_operation_errors_total{operation="retrieve_account",type="not_found"} 1
+# HELP test_vault_request_errors_total The number of requests to Vault that failed, by type of error.
+# TYPE test_vault_request_errors_total counter
+test_vault_request_errors_total{request="read",type="unavailable"} 1
+# HELP test_cache_lookups_total The number of cache lookups, by result.
+# TYPE test_cache_lookups_total counter
+test_cache_lookups_total{cache="pubkeys",result="hit"} 1
+test_cache_lookups_total{cache="pubkeys",result="miss"} 2
+# HELP test_token_renewals_total The number of attempts to renew the Vault token, by result.
+# TYPE test_token_renewals_total counter
+test_token_renewals_total{result="success"} 1
+# HELP test_retries_total The number of retried requests.
+# TYPE test_retries_total counter
+test_retries_total{request="login"} 1
+`), "test_operation_errors_total", "test_vault_request_errors_total", "test_cache_lookups_total", "test_token_renewals_total", "test_retries_total"))
+
+	// Registering the same metrics twice fails.
+	_, err = prometheus.New(prometheus.WithRegisterer(registry), prometheus.WithNamespace("test"))
+	assert.NotNil(t, err)
+}
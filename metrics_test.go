@@ -0,0 +1,92 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingMetrics records the operations and requests it observes.
+type recordingMetrics struct {
+	mu         sync.Mutex
+	operations map[string][]string
+	requests   map[string][]string
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		operations: make(map[string][]string),
+		requests:   make(map[string][]string),
+	}
+}
+
+func (m *recordingMetrics) ObserveOperation(operation string, _ time.Duration, errorType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operations[operation] = append(m.operations[operation], errorType)
+}
+
+func (m *recordingMetrics) ObserveVaultRequest(request string, _ time.Duration, errorType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[request] = append(m.requests[request], errorType)
+}
+
+func (m *recordingMetrics) CacheLookup(string, bool) {}
+func (m *recordingMetrics) TokenRenewal(bool)        {}
+func (m *recordingMetrics) Retry(string)             {}
+
+func TestMetrics(t *testing.T) {
+	metrics := newRecordingMetrics()
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithMetrics(metrics),
+	)
+	require.Nil(t, err)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "metrics wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"metrics wallet"}`, walletID))))
+	_, err = store.RetrieveAccount(walletID, uuid.New())
+	require.NotNil(t, err)
+
+	readOnly, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithReadOnly(),
+		vault.WithMetrics(metrics),
+	)
+	require.Nil(t, err)
+	require.NotNil(t, readOnly.StoreWallet(walletID, "metrics wallet", []byte("{}")))
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	assert.Equal(t, []string{"", vault.ErrorTypeReadOnly}, metrics.operations["store_wallet"])
+	assert.Equal(t, []string{vault.ErrorTypeNotFound}, metrics.operations["retrieve_account"])
+	assert.Contains(t, metrics.requests["read"], vault.ErrorTypeNotFound)
+	assert.Contains(t, metrics.requests["write"], "")
+}
@@ -0,0 +1,245 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// migrationOptions are the options for a migration.
+type migrationOptions struct {
+	dryRun bool
+	verify bool
+}
+
+// MigrationOption gives options to Migrate
+type MigrationOption interface {
+	apply(*migrationOptions)
+}
+
+type migrationOptionFunc func(*migrationOptions)
+
+func (f migrationOptionFunc) apply(o *migrationOptions) {
+	f(o)
+}
+
+// WithMigrationDryRun reports what would be migrated without writing to the store.
+func WithMigrationDryRun() MigrationOption {
+	return migrationOptionFunc(func(o *migrationOptions) {
+		o.dryRun = true
+	})
+}
+
+// WithMigrationVerification re-reads each item after it is written and checks it matches the source.
+func WithMigrationVerification() MigrationOption {
+	return migrationOptionFunc(func(o *migrationOptions) {
+		o.verify = true
+	})
+}
+
+// MigrationReport is the result of a migration.
+// In a dry run the migrated counts are of the items that would have been migrated.
+type MigrationReport struct {
+	// WalletsMigrated is the number of wallets written to the store.
+	WalletsMigrated int
+	// WalletsSkipped is the number of wallets already present in the store.
+	WalletsSkipped int
+	// AccountsMigrated is the number of accounts written to the store.
+	AccountsMigrated int
+	// AccountsSkipped is the number of accounts already present in the store.
+	AccountsSkipped int
+	// IndicesMigrated is the number of accounts indices written to the store.
+	IndicesMigrated int
+	// IndicesSkipped is the number of accounts indices already present in the store.
+	IndicesSkipped int
+}
+
+// migrationInfo is the identifying information of a wallet or account.
+type migrationInfo struct {
+	ID   uuid.UUID `json:"uuid"`
+	Name string    `json:"name"`
+}
+
+// Migrate copies all wallets, accounts and accounts indices from the source store in to this store.
+// Items already present in this store with identical data are skipped, so an interrupted migration can be resumed
+// by running it again.  Items present with different data are not overwritten; the migration stops with an error, as
+// it does if it cannot tell whether an item is present, for example because the existing item cannot be decrypted.
+func (s *Store) Migrate(source wtypes.Store, opts ...MigrationOption) (*MigrationReport, error) {
+	options := migrationOptions{}
+	for _, o := range opts {
+		o.apply(&options)
+	}
+
+	if !options.dryRun {
+		if err := s.checkWritable("migrate"); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &MigrationReport{}
+	for walletData := range source.RetrieveWallets() {
+		wallet := &migrationInfo{}
+		if err := json.Unmarshal(walletData, wallet); err != nil {
+			return report, errors.Wrap(err, "failed to parse source wallet")
+		}
+
+		migrated, err := s.migrateWallet(wallet, walletData, &options)
+		if err != nil {
+			return report, err
+		}
+		if migrated {
+			report.WalletsMigrated++
+		} else {
+			report.WalletsSkipped++
+		}
+
+		for accountData := range source.RetrieveAccounts(wallet.ID) {
+			account := &migrationInfo{}
+			if err := json.Unmarshal(accountData, account); err != nil {
+				return report, errors.Wrap(err, fmt.Sprintf("failed to parse source account in wallet %s", wallet.ID))
+			}
+			migrated, err := s.migrateAccount(wallet.ID, account, accountData, &options)
+			if err != nil {
+				return report, err
+			}
+			if migrated {
+				report.AccountsMigrated++
+			} else {
+				report.AccountsSkipped++
+			}
+		}
+
+		indexData, err := source.RetrieveAccountsIndex(wallet.ID)
+		if err != nil {
+			// No index for this wallet.
+			continue
+		}
+		migrated, err = s.migrateIndex(wallet.ID, indexData, &options)
+		if err != nil {
+			return report, err
+		}
+		if migrated {
+			report.IndicesMigrated++
+		} else {
+			report.IndicesSkipped++
+		}
+	}
+
+	return report, nil
+}
+
+// migrateWallet migrates a single wallet, returning true if it was written.
+func (s *Store) migrateWallet(wallet *migrationInfo, data []byte, options *migrationOptions) (bool, error) {
+	existing, err := s.retrieveWalletHeader(context.Background(), wallet.ID)
+	if err == nil {
+		if !bytes.Equal(existing, data) {
+			return false, fmt.Errorf("wallet %s already exists with different data", wallet.Name)
+		}
+		return false, nil
+	}
+	if errorType(err) != ErrorTypeNotFound {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to check for existing wallet %s", wallet.Name))
+	}
+
+	if options.dryRun {
+		return true, nil
+	}
+	if err := s.StoreWallet(wallet.ID, wallet.Name, data); err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to migrate wallet %s", wallet.Name))
+	}
+	if options.verify {
+		stored, err := s.RetrieveWalletByID(wallet.ID)
+		if err != nil || !bytes.Equal(stored, data) {
+			return false, fmt.Errorf("verification of wallet %s failed", wallet.Name)
+		}
+	}
+	return true, nil
+}
+
+// retrieveWalletHeader retrieves the data of the wallet with the given ID.  Unlike retrieval by listing, a wallet that
+// cannot be retrieved or decrypted returns an error rather than being skipped.
+func (s *Store) retrieveWalletHeader(ctx context.Context, walletID uuid.UUID) ([]byte, error) {
+	binding := walletBinding(walletID)
+	secret, err := s.kvGet(ctx, s.walletHeaderPath(walletID))
+	if err != nil {
+		s.audit(ctx, AuditRead, binding, err)
+		return nil, err
+	}
+	data, err := s.openSecret(secret, binding)
+	s.audit(ctx, AuditRead, binding, err)
+	return data, err
+}
+
+// migrateAccount migrates a single account, returning true if it was written.
+func (s *Store) migrateAccount(walletID uuid.UUID, account *migrationInfo, data []byte, options *migrationOptions) (bool, error) {
+	existing, err := s.RetrieveAccount(walletID, account.ID)
+	if err == nil {
+		if !bytes.Equal(existing, data) {
+			return false, fmt.Errorf("account %s in wallet %s already exists with different data", account.ID, walletID)
+		}
+		return false, nil
+	}
+	if errorType(err) != ErrorTypeNotFound {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to check for existing account %s in wallet %s", account.ID, walletID))
+	}
+
+	if options.dryRun {
+		return true, nil
+	}
+	if err := s.StoreAccount(walletID, account.ID, data); err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to migrate account %s in wallet %s", account.ID, walletID))
+	}
+	if options.verify {
+		stored, err := s.RetrieveAccount(walletID, account.ID)
+		if err != nil || !bytes.Equal(stored, data) {
+			return false, fmt.Errorf("verification of account %s in wallet %s failed", account.ID, walletID)
+		}
+	}
+	return true, nil
+}
+
+// migrateIndex migrates the accounts index of a wallet, returning true if it was written.
+func (s *Store) migrateIndex(walletID uuid.UUID, data []byte, options *migrationOptions) (bool, error) {
+	existing, err := s.RetrieveAccountsIndex(walletID)
+	if err == nil {
+		if !bytes.Equal(existing, data) {
+			return false, fmt.Errorf("accounts index for wallet %s already exists with different data", walletID)
+		}
+		return false, nil
+	}
+	if errorType(err) != ErrorTypeNotFound {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to check for existing accounts index for wallet %s", walletID))
+	}
+
+	if options.dryRun {
+		return true, nil
+	}
+	if err := s.StoreAccountsIndex(walletID, data); err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to migrate accounts index for wallet %s", walletID))
+	}
+	if options.verify {
+		stored, err := s.RetrieveAccountsIndex(walletID)
+		if err != nil || !bytes.Equal(stored, data) {
+			return false, fmt.Errorf("verification of accounts index for wallet %s failed", walletID)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a minimal in-memory wallet store used as a migration source.
+type memStore struct {
+	wallets  map[uuid.UUID][]byte
+	accounts map[uuid.UUID]map[uuid.UUID][]byte
+	indices  map[uuid.UUID][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		wallets:  make(map[uuid.UUID][]byte),
+		accounts: make(map[uuid.UUID]map[uuid.UUID][]byte),
+		indices:  make(map[uuid.UUID][]byte),
+	}
+}
+
+func (s *memStore) Name() string { return "mem" }
+
+func (s *memStore) StoreWallet(walletID uuid.UUID, _ string, data []byte) error {
+	s.wallets[walletID] = data
+	s.accounts[walletID] = make(map[uuid.UUID][]byte)
+	return nil
+}
+
+func (s *memStore) RetrieveWallets() <-chan []byte {
+	ch := make(chan []byte, len(s.wallets))
+	for _, data := range s.wallets {
+		ch <- data
+	}
+	close(ch)
+	return ch
+}
+
+func (s *memStore) RetrieveWallet(_ string) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *memStore) RetrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
+	data, exists := s.wallets[walletID]
+	if !exists {
+		return nil, errors.New("wallet not found")
+	}
+	return data, nil
+}
+
+func (s *memStore) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	s.accounts[walletID][accountID] = data
+	return nil
+}
+
+func (s *memStore) RetrieveAccounts(walletID uuid.UUID) <-chan []byte {
+	ch := make(chan []byte, len(s.accounts[walletID]))
+	for _, data := range s.accounts[walletID] {
+		ch <- data
+	}
+	close(ch)
+	return ch
+}
+
+func (s *memStore) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte, error) {
+	data, exists := s.accounts[walletID][accountID]
+	if !exists {
+		return nil, errors.New("account not found")
+	}
+	return data, nil
+}
+
+func (s *memStore) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
+	s.indices[walletID] = data
+	return nil
+}
+
+func (s *memStore) RetrieveAccountsIndex(walletID uuid.UUID) ([]byte, error) {
+	data, exists := s.indices[walletID]
+	if !exists {
+		return nil, errors.New("index not found")
+	}
+	return data, nil
+}
+
+func TestMigrate(t *testing.T) {
+	source := newMemStore()
+	walletIDs := []uuid.UUID{uuid.New(), uuid.New()}
+	accountIDs := make([]uuid.UUID, 0)
+	for i, walletID := range walletIDs {
+		walletName := fmt.Sprintf("migrate wallet %s", walletID)
+		require.Nil(t, source.StoreWallet(walletID, walletName, []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, walletID, walletName))))
+		for j := 0; j < 3; j++ {
+			accountID := uuid.New()
+			accountIDs = append(accountIDs, accountID)
+			require.Nil(t, source.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d"}`, accountID, j))))
+		}
+		if i == 0 {
+			require.Nil(t, source.StoreAccountsIndex(walletID, []byte(`{"index":"test index data"}`)))
+		}
+	}
+
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+	vaultStore := store.(*vault.Store)
+
+	// Dry run writes nothing.
+	report, err := vaultStore.Migrate(source, vault.WithMigrationDryRun())
+	require.Nil(t, err)
+	assert.Equal(t, &vault.MigrationReport{WalletsMigrated: 2, AccountsMigrated: 6, IndicesMigrated: 1}, report)
+	_, err = store.RetrieveWalletByID(walletIDs[0])
+	assert.NotNil(t, err)
+
+	report, err = vaultStore.Migrate(source, vault.WithMigrationVerification())
+	require.Nil(t, err)
+	assert.Equal(t, &vault.MigrationReport{WalletsMigrated: 2, AccountsMigrated: 6, IndicesMigrated: 1}, report)
+	for _, walletID := range walletIDs {
+		data, err := store.RetrieveWalletByID(walletID)
+		require.Nil(t, err)
+		assert.Equal(t, source.wallets[walletID], data)
+		for accountID, accountData := range source.accounts[walletID] {
+			data, err := store.RetrieveAccount(walletID, accountID)
+			require.Nil(t, err)
+			assert.Equal(t, accountData, data)
+		}
+	}
+	index, err := store.RetrieveAccountsIndex(walletIDs[0])
+	require.Nil(t, err)
+	assert.Equal(t, source.indices[walletIDs[0]], index)
+
+	// Running again skips everything.
+	report, err = vaultStore.Migrate(source)
+	require.Nil(t, err)
+	assert.Equal(t, &vault.MigrationReport{WalletsSkipped: 2, AccountsSkipped: 6, IndicesSkipped: 1}, report)
+
+	// Conflicting data is not overwritten.
+	source.accounts[walletIDs[1]][accountIDs[4]] = []byte(fmt.Sprintf(`{"uuid":%q,"name":"changed"}`, accountIDs[4]))
+	_, err = vaultStore.Migrate(source)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "already exists with different data")
+
+	// Data that cannot be decrypted is not treated as absent and overwritten.
+	wrongKeyStore, err := vault.New(
+		vault.WithPassphrase([]byte("wrong")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+	_, err = wrongKeyStore.(*vault.Store).Migrate(source)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to check for existing wallet")
+	data, err := store.RetrieveWalletByID(walletIDs[0])
+	require.Nil(t, err)
+	assert.Equal(t, source.wallets[walletIDs[0]], data)
+}
+
+func TestMigrateReadOnly(t *testing.T) {
+	store, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithReadOnly(),
+	)
+	require.Nil(t, err)
+
+	_, err = store.(*vault.Store).Migrate(newMemStore())
+	var readOnlyErr *vault.ReadOnlyError
+	assert.True(t, errors.As(err, &readOnlyErr))
+}
@@ -27,9 +27,20 @@ func (s *Store) walletHeaderPath(walletID uuid.UUID) string {
 }
 
 func (s *Store) accountPath(walletID uuid.UUID, accountID uuid.UUID) string {
+	return s.accountPathInLayout(walletID, accountID, s.account_layout)
+}
+
+func (s *Store) accountPathInLayout(walletID uuid.UUID, accountID uuid.UUID, layout string) string {
+	if layout == AccountLayoutSharded {
+		return fmt.Sprintf("wallets/%s/accounts/%s/%s", s.walletPath(walletID), accountShard(accountID), accountID.String())
+	}
 	return fmt.Sprintf("wallets/%s/%s", s.walletPath(walletID), accountID.String())
 }
 
 func (s *Store) walletIndexPath(walletID uuid.UUID) string {
 	return fmt.Sprintf("wallets/%s/index", s.walletPath(walletID))
 }
+
+func (s *Store) publicKeyIndexPath() string {
+	return "indices/pubkeys"
+}
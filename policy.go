@@ -0,0 +1,67 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"errors"
+)
+
+// PolicyAccess is the level of access granted by a generated policy.
+type PolicyAccess int
+
+const (
+	// PolicyReadWrite allows wallets, accounts and indices to be read and written, but not deleted.
+	PolicyReadWrite PolicyAccess = iota
+	// PolicyReadOnly allows wallets, accounts and indices to be read but not written.
+	PolicyReadOnly
+	// PolicyReadWriteDelete allows wallets, accounts and indices to be read and written, and wallets and accounts to
+	// be deleted.
+	PolicyReadWriteDelete
+)
+
+// GeneratePolicy generates the least-privilege Vault policy, in HCL, for a store with the given options.
+// Only the options that affect the paths used by the store are considered:
+//   - vault_secrets_mount_path: the KV secrets module path, required, set with WithVaultSecretMountPath()
+//   - vault_kv_version: the KV secrets module version, defaults to 2, set with WithVaultKVVersion()
+//   - custom_metadata: allow access to custom metadata, set with WithCustomMetadata()
+//
+// Vault is not contacted, so the KV version cannot be detected.
+func GeneratePolicy(access PolicyAccess, opts ...Option) (string, error) {
+	options := options{
+		vault_kv_version: 2,
+	}
+	for _, o := range opts {
+		o.apply(&options)
+	}
+
+	if options.vault_secrets_mount_path == "" {
+		return "", errors.New("vault_secrets_mount_path option missing")
+	}
+	if options.vault_kv_version != 1 && options.vault_kv_version != 2 {
+		return "", errors.New("vault_kv_version option must be 1 or 2")
+	}
+	if options.custom_metadata && options.vault_kv_version != 2 {
+		return "", errors.New("custom_metadata option requires KV version 2")
+	}
+	if access != PolicyReadWrite && access != PolicyReadOnly && access != PolicyReadWriteDelete {
+		return "", errors.New("unknown policy access")
+	}
+
+	store := &Store{
+		vault_secrets_mount_path: options.vault_secrets_mount_path,
+		vault_kv_version:         options.vault_kv_version,
+		custom_metadata:          options.custom_metadata,
+	}
+	return renderPolicy(store.requiredCapabilities(access)), nil
+}
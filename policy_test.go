@@ -0,0 +1,192 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	"testing"
+
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		access vault.PolicyAccess
+		opts   []vault.Option
+		policy string
+		err    string
+	}{
+		{
+			name:   "MountMissing",
+			access: vault.PolicyReadWrite,
+			err:    "vault_secrets_mount_path option missing",
+		},
+		{
+			name:   "BadKVVersion",
+			access: vault.PolicyReadWrite,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("secret"), vault.WithVaultKVVersion(3)},
+			err:    "vault_kv_version option must be 1 or 2",
+		},
+		{
+			name:   "BadAccess",
+			access: vault.PolicyAccess(-1),
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("secret")},
+			err:    "unknown policy access",
+		},
+		{
+			name:   "ReadWrite",
+			access: vault.PolicyReadWrite,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("secret")},
+			policy: `path "secret/metadata/wallets" {
+  capabilities = ["list"]
+}
+
+path "secret/metadata/wallets/*" {
+  capabilities = ["list"]
+}
+
+path "secret/data/wallets/*" {
+  capabilities = ["create", "read", "update"]
+}
+
+path "secret/data/indices/pubkeys" {
+  capabilities = ["create", "read", "update"]
+}
+`,
+		},
+		{
+			name:   "ReadWriteDelete",
+			access: vault.PolicyReadWriteDelete,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("secret")},
+			policy: `path "secret/metadata/wallets" {
+  capabilities = ["list"]
+}
+
+path "secret/metadata/wallets/*" {
+  capabilities = ["list", "delete"]
+}
+
+path "secret/data/wallets/*" {
+  capabilities = ["create", "read", "update"]
+}
+
+path "secret/data/indices/pubkeys" {
+  capabilities = ["create", "read", "update"]
+}
+`,
+		},
+		{
+			name:   "ReadOnly",
+			access: vault.PolicyReadOnly,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("secret")},
+			policy: `path "secret/metadata/wallets" {
+  capabilities = ["list"]
+}
+
+path "secret/metadata/wallets/*" {
+  capabilities = ["list"]
+}
+
+path "secret/data/wallets/*" {
+  capabilities = ["read"]
+}
+
+path "secret/data/indices/pubkeys" {
+  capabilities = ["read"]
+}
+`,
+		},
+		{
+			name:   "CustomMetadataKVv1",
+			access: vault.PolicyReadWrite,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("kv1"), vault.WithVaultKVVersion(1), vault.WithCustomMetadata()},
+			err:    "custom_metadata option requires KV version 2",
+		},
+		{
+			name:   "CustomMetadata",
+			access: vault.PolicyReadWriteDelete,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("secret"), vault.WithCustomMetadata()},
+			policy: `path "secret/metadata/wallets" {
+  capabilities = ["list"]
+}
+
+path "secret/metadata/wallets/*" {
+  capabilities = ["list", "delete", "read", "create", "update"]
+}
+
+path "secret/data/wallets/*" {
+  capabilities = ["create", "read", "update"]
+}
+
+path "secret/data/indices/pubkeys" {
+  capabilities = ["create", "read", "update"]
+}
+`,
+		},
+		{
+			name:   "ReadOnlyKVv1",
+			access: vault.PolicyReadOnly,
+			opts:   []vault.Option{vault.WithVaultSecretMountPath("kv1"), vault.WithVaultKVVersion(1)},
+			policy: `path "kv1/wallets" {
+  capabilities = ["list"]
+}
+
+path "kv1/wallets/*" {
+  capabilities = ["list", "read"]
+}
+
+path "kv1/indices/pubkeys" {
+  capabilities = ["read"]
+}
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := vault.GeneratePolicy(test.access, test.opts...)
+			if test.err != "" {
+				require.NotNil(t, err)
+				assert.Equal(t, test.err, err.Error())
+			} else {
+				require.Nil(t, err)
+				assert.Equal(t, test.policy, policy)
+			}
+		})
+	}
+}
+
+func TestGeneratePolicyReadOnlyToken(t *testing.T) {
+	policy, err := vault.GeneratePolicy(vault.PolicyReadOnly, vault.WithVaultSecretMountPath("secret"))
+	require.Nil(t, err)
+	token := createPolicyToken(t, "wallets-generated-read", policy)
+
+	store, err := vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultKVVersion(2),
+		vault.WithVaultToken(token),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+
+	health, err := store.(*vault.Store).Health(context.Background())
+	require.Nil(t, err)
+	assert.True(t, health.MountReachable)
+	assert.True(t, health.CanRead)
+	assert.False(t, health.CanWrite)
+}
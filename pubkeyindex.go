@@ -0,0 +1,238 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// publicKeyIndexEntry is the location of an account in the public key index.
+type publicKeyIndexEntry struct {
+	WalletID  uuid.UUID `json:"wallet"`
+	AccountID uuid.UUID `json:"account"`
+}
+
+// publicKeyIndex maps hex-encoded public keys to account locations.
+type publicKeyIndex map[string]*publicKeyIndexEntry
+
+// RetrieveAccountByPublicKey retrieves account-level data for the account with the given public key, along with the
+// ID of the wallet that holds it.
+func (s *Store) RetrieveAccountByPublicKey(pubKey []byte) (_ uuid.UUID, _ []byte, err error) {
+	ctx, op := s.startOperation(context.Background(), "retrieve_account_by_public_key")
+	defer op.end(&err)
+	index, _, err := s.retrievePublicKeyIndex(ctx)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	entry, exists := index[hex.EncodeToString(pubKey)]
+	if !exists {
+		s.metrics.CacheLookup("pubkeys", false)
+		return uuid.Nil, nil, errAccountNotFound
+	}
+	data, err := s.retrieveAccount(ctx, entry.WalletID, entry.AccountID)
+	if err != nil {
+		s.metrics.CacheLookup("pubkeys", false)
+		return uuid.Nil, nil, errAccountNotFound
+	}
+	// Guard against a stale index entry.
+	if accountPublicKey(data) != hex.EncodeToString(pubKey) {
+		s.metrics.CacheLookup("pubkeys", false)
+		return uuid.Nil, nil, errAccountNotFound
+	}
+	s.metrics.CacheLookup("pubkeys", true)
+	return entry.WalletID, data, nil
+}
+
+// RebuildPublicKeyIndex rebuilds the public key index from all accounts in the store.
+// This is only required for accounts stored before the index was maintained, or whose index update failed.
+// The rebuild fails if any account cannot be read or decrypted, or if another store changes the index meanwhile.
+func (s *Store) RebuildPublicKeyIndex() (err error) {
+	if err := s.checkWritable("rebuild public key index"); err != nil {
+		return err
+	}
+	ctx, op := s.startOperation(context.Background(), "rebuild_public_key_index")
+	defer op.end(&err)
+
+	s.pubKeyIndexMu.Lock()
+	defer s.pubKeyIndexMu.Unlock()
+	// The existing index is replaced rather than read, so only its version is required.
+	_, version, err := s.kvGetVersion(ctx, s.publicKeyIndexPath())
+	if err != nil && !errors.Is(err, vault.ErrSecretNotFound) {
+		return errors.Wrap(err, "failed to retrieve public key index")
+	}
+
+	index := make(publicKeyIndex)
+	err = s.walkSecrets(ctx, func(ctx context.Context, path string, binding *secretBinding) error {
+		if binding.kind != secretKindAccount {
+			return nil
+		}
+		secret, err := s.kvGet(ctx, path)
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			// Removed since listing.
+			return nil
+		}
+		if err != nil {
+			s.audit(ctx, AuditRead, binding, err)
+			return errors.Wrap(err, fmt.Sprintf("failed to retrieve %s", binding))
+		}
+		data, err := s.openSecret(secret, binding)
+		s.audit(ctx, AuditRead, binding, err)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to decrypt %s", binding))
+		}
+		if pubKey := accountPublicKey(data); pubKey != "" {
+			index[pubKey] = &publicKeyIndexEntry{
+				WalletID:  binding.walletID,
+				AccountID: binding.accountID,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.storePublicKeyIndex(ctx, index, vault.WithCheckAndSet(version))
+}
+
+// publicKeyIndexAttempts is the number of times an update to the public key index is attempted when it is changed
+// concurrently by another store.
+const publicKeyIndexAttempts = 5
+
+// indexAccountPublicKey adds an account to the public key index.
+// Accounts without a public key, such as those in wallets that do not expose it, are not indexed.
+func (s *Store) indexAccountPublicKey(ctx context.Context, walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	pubKey := accountPublicKey(data)
+	if pubKey == "" {
+		return nil
+	}
+	return s.updatePublicKeyIndex(ctx, func(index publicKeyIndex) bool {
+		if entry, exists := index[pubKey]; exists && entry.WalletID == walletID && entry.AccountID == accountID {
+			return false
+		}
+		index[pubKey] = &publicKeyIndexEntry{
+			WalletID:  walletID,
+			AccountID: accountID,
+		}
+		return true
+	})
+}
+
+// unindexAccountPublicKey removes an account from the public key index.
+func (s *Store) unindexAccountPublicKey(ctx context.Context, walletID uuid.UUID, accountID uuid.UUID) error {
+	return s.updatePublicKeyIndex(ctx, func(index publicKeyIndex) bool {
+		updated := false
+		for pubKey, entry := range index {
+			if entry.WalletID == walletID && entry.AccountID == accountID {
+				delete(index, pubKey)
+				updated = true
+			}
+		}
+		return updated
+	})
+}
+
+// updatePublicKeyIndex applies an update to the public key index, which returns false if it made no change.
+// Updates made by this store are serialised.  With KV version 2 the index is written with check-and-set, and the
+// update is reapplied to a fresh copy of the index if another store changed it in the meantime; KV version 1 offers no
+// such protection against other stores.
+func (s *Store) updatePublicKeyIndex(ctx context.Context, update func(publicKeyIndex) bool) error {
+	s.pubKeyIndexMu.Lock()
+	defer s.pubKeyIndexMu.Unlock()
+	for attempt := 1; ; attempt++ {
+		index, version, err := s.retrievePublicKeyIndex(ctx)
+		if err != nil {
+			return err
+		}
+		if !update(index) {
+			return nil
+		}
+		err = s.storePublicKeyIndex(ctx, index, vault.WithCheckAndSet(version))
+		if err == nil || !isCheckAndSetMismatch(err) || attempt == publicKeyIndexAttempts {
+			return err
+		}
+		s.metrics.Retry("write_pubkeys")
+	}
+}
+
+// retrievePublicKeyIndex retrieves the public key index and its version, returning an empty index with version 0 if
+// there is none.
+func (s *Store) retrievePublicKeyIndex(ctx context.Context) (publicKeyIndex, int, error) {
+	secret, version, err := s.kvGetVersion(ctx, s.publicKeyIndexPath())
+	if errors.Is(err, vault.ErrSecretNotFound) {
+		return make(publicKeyIndex), 0, nil
+	}
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to retrieve public key index")
+	}
+	data, err := s.openSecret(secret, publicKeyIndexBinding())
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to decrypt public key index")
+	}
+	index := make(publicKeyIndex)
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to parse public key index")
+	}
+	return index, version, nil
+}
+
+// storePublicKeyIndex stores the public key index.
+func (s *Store) storePublicKeyIndex(ctx context.Context, index publicKeyIndex, opts ...vault.KVOption) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to create public key index")
+	}
+	secret, err := s.sealSecret(data, publicKeyIndexBinding())
+	if err != nil {
+		return err
+	}
+	err = s.kvPut(ctx, s.publicKeyIndexPath(), secret, opts...)
+	if err != nil {
+		return errors.Wrap(err, "failed to store public key index")
+	}
+	return nil
+}
+
+// accountPublicKey returns the hex-encoded public key of an account, or an empty string if it has none.
+func accountPublicKey(data []byte) string {
+	info := &struct {
+		PubKey string `json:"pubkey"`
+	}{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return ""
+	}
+	return normalizePubKey(info.PubKey)
+}
+
+// isCheckAndSetMismatch returns true if a write failed because the secret had changed since it was read.
+func isCheckAndSetMismatch(err error) bool {
+	var responseErr *vault.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	for _, message := range responseErr.Errors {
+		if strings.Contains(message, "check-and-set") {
+			return true
+		}
+	}
+	return false
+}
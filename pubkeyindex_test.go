@@ -0,0 +1,131 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrieveAccountByPublicKey(t *testing.T) {
+	store := newFreshMountStore(t)
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "pubkey wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"pubkey wallet"}`, walletID))))
+
+	pubKey, err := hex.DecodeString("a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c")
+	require.Nil(t, err)
+	_, _, err = store.RetrieveAccountByPublicKey(pubKey)
+	require.NotNil(t, err)
+	assert.Equal(t, "account not found", err.Error())
+
+	accountID := uuid.New()
+	accountData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"pubkey account","pubkey":"0xA99A76ED7796F7BE22D5B7E85DEEB7C5677E88E511E0B337618F8C4EB61349B4BF2D153F649F7B53359FE8B94A38E44C"}`, accountID))
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData))
+	// An account without a public key is not indexed.
+	require.Nil(t, store.StoreAccount(walletID, uuid.New(), []byte(fmt.Sprintf(`{"uuid":%q,"name":"no pubkey account"}`, uuid.New()))))
+
+	foundWalletID, data, err := store.RetrieveAccountByPublicKey(pubKey)
+	require.Nil(t, err)
+	assert.Equal(t, walletID, foundWalletID)
+	assert.Equal(t, accountData, data)
+
+	// Deleting the account removes it from the index.
+	require.Nil(t, store.DeleteAccount(walletID, accountID))
+	_, _, err = store.RetrieveAccountByPublicKey(pubKey)
+	require.NotNil(t, err)
+
+	// Rebuilding the index picks up existing accounts.
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData))
+	require.Nil(t, store.RebuildPublicKeyIndex())
+	foundWalletID, data, err = store.RetrieveAccountByPublicKey(pubKey)
+	require.Nil(t, err)
+	assert.Equal(t, walletID, foundWalletID)
+	assert.Equal(t, accountData, data)
+}
+
+func TestPublicKeyIndexConcurrentUpdates(t *testing.T) {
+	// Two stores sharing a KV version 2 mount update the index at the same time.
+	mount := fmt.Sprintf("pubkeys-%s", uuid.New())
+	mountKV(t, mount, "2")
+	stores := make([]*vault.Store, 2)
+	for i := range stores {
+		store, err := vault.New(
+			vault.WithPassphrase([]byte("test")),
+			vault.WithVaultAddr("http://localhost:8200"),
+			vault.WithVaultSecretMountPath(mount),
+			vault.WithVaultToken("golang-test"),
+			vault.WithVaultAuth("token"),
+		)
+		require.Nil(t, err)
+		stores[i] = store.(*vault.Store)
+	}
+	walletID := uuid.New()
+	require.Nil(t, stores[0].StoreWallet(walletID, "pubkey wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"pubkey wallet"}`, walletID))))
+
+	pubKeys := make([][]byte, 6)
+	var wg sync.WaitGroup
+	for i := range pubKeys {
+		pubKeys[i] = make([]byte, 48)
+		pubKeys[i][0] = byte(i + 1)
+		accountID := uuid.New()
+		data := []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d","pubkey":"%x"}`, accountID, i, pubKeys[i]))
+		wg.Add(1)
+		go func(store *vault.Store) {
+			defer wg.Done()
+			assert.Nil(t, store.StoreAccount(walletID, accountID, data))
+		}(stores[i%len(stores)])
+	}
+	wg.Wait()
+
+	for _, pubKey := range pubKeys {
+		foundWalletID, _, err := stores[0].RetrieveAccountByPublicKey(pubKey)
+		require.Nil(t, err)
+		assert.Equal(t, walletID, foundWalletID)
+	}
+}
+
+func TestRebuildPublicKeyIndexUndecryptable(t *testing.T) {
+	mount := fmt.Sprintf("pubkeys-%s", uuid.New())
+	mountKV(t, mount, "2")
+	newStore := func(passphrase string) *vault.Store {
+		store, err := vault.New(
+			vault.WithPassphrase([]byte(passphrase)),
+			vault.WithVaultAddr("http://localhost:8200"),
+			vault.WithVaultSecretMountPath(mount),
+			vault.WithVaultToken("golang-test"),
+			vault.WithVaultAuth("token"),
+		)
+		require.Nil(t, err)
+		return store.(*vault.Store)
+	}
+
+	// An account that the rebuilding store cannot decrypt fails the rebuild, rather than being left out of the index.
+	other := newStore("other")
+	walletID := uuid.New()
+	require.Nil(t, other.StoreWallet(walletID, "pubkey wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"pubkey wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.Nil(t, other.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"pubkey account","pubkey":"a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c"}`, accountID))))
+	err := newStore("test").RebuildPublicKeyIndex()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to decrypt account")
+
+	require.Nil(t, other.RebuildPublicKeyIndex())
+}
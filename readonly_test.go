@@ -0,0 +1,103 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnly(t *testing.T) {
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+
+	walletID := uuid.New()
+	walletName := fmt.Sprintf("test wallet %s", walletID)
+	walletData := []byte(fmt.Sprintf(`{"name":%q,"uuid":%q}`, walletName, walletID.String()))
+	accountID := uuid.New()
+	accountData := []byte(fmt.Sprintf(`{"name":"test account","uuid":%q}`, accountID.String()))
+	require.Nil(t, store.StoreWallet(walletID, walletName, walletData))
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData))
+
+	// Open the store read-only with a token that can only read, checking capabilities up front.
+	policy, err := vault.GeneratePolicy(vault.PolicyReadOnly, vault.WithVaultSecretMountPath("secret"))
+	require.Nil(t, err)
+	token := createPolicyToken(t, "wallets-read-only", policy)
+	roStore, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultKVVersion(2),
+		vault.WithVaultToken(token),
+		vault.WithVaultAuth("token"),
+		vault.WithReadOnly(),
+		vault.WithPreflight(),
+	)
+	require.Nil(t, err)
+	assert.Equal(t, policy, roStore.(*vault.Store).RequiredPolicy())
+
+	retWalletData, err := roStore.RetrieveWallet(walletName)
+	require.Nil(t, err)
+	assert.Equal(t, walletData, retWalletData)
+	retAccountData, err := roStore.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, accountData, retAccountData)
+
+	var readOnlyErr *vault.ReadOnlyError
+	err = roStore.StoreWallet(walletID, walletName, walletData)
+	require.True(t, errors.As(err, &readOnlyErr))
+	assert.Equal(t, "store wallet", readOnlyErr.Operation)
+	err = roStore.StoreAccount(walletID, accountID, accountData)
+	require.True(t, errors.As(err, &readOnlyErr))
+	assert.Equal(t, "store account", readOnlyErr.Operation)
+	err = roStore.StoreAccountsIndex(walletID, []byte("{}"))
+	require.True(t, errors.As(err, &readOnlyErr))
+	assert.Equal(t, "store accounts index", readOnlyErr.Operation)
+	err = roStore.(*vault.Store).DeleteAccount(walletID, accountID)
+	require.True(t, errors.As(err, &readOnlyErr))
+	assert.Equal(t, "delete account", readOnlyErr.Operation)
+	err = roStore.(*vault.Store).DeleteWallet(walletID)
+	require.True(t, errors.As(err, &readOnlyErr))
+	assert.Equal(t, "delete wallet", readOnlyErr.Operation)
+}
+
+func TestReadOnlyPreflightWithoutReadOnly(t *testing.T) {
+	policy, err := vault.GeneratePolicy(vault.PolicyReadOnly, vault.WithVaultSecretMountPath("secret"))
+	require.Nil(t, err)
+	token := createPolicyToken(t, "wallets-read-only", policy)
+
+	// A read-write store requires write capabilities.
+	_, err = vault.New(
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultKVVersion(2),
+		vault.WithVaultToken(token),
+		vault.WithVaultAuth("token"),
+		vault.WithPreflight(),
+	)
+	var missingErr *vault.MissingCapabilitiesError
+	require.True(t, errors.As(err, &missingErr))
+}
@@ -0,0 +1,589 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// replicationOptions are the options for a replicated store.
+type replicationOptions struct {
+	async     bool
+	queueSize int
+}
+
+// ReplicationOption gives options to NewReplicatedStore
+type ReplicationOption interface {
+	apply(*replicationOptions)
+}
+
+type replicationOptionFunc func(*replicationOptions)
+
+func (f replicationOptionFunc) apply(o *replicationOptions) {
+	f(o)
+}
+
+// WithAsyncReplication replicates writes to the secondary stores in the background rather than before the write
+// returns.  Up to queueSize writes are queued for each secondary; further writes wait for space in the queue.
+func WithAsyncReplication(queueSize int) ReplicationOption {
+	return replicationOptionFunc(func(o *replicationOptions) {
+		o.async = true
+		o.queueSize = queueSize
+	})
+}
+
+// ReplicatedStore is a wallet store that writes to a primary store and replicates each write to one or more
+// secondary stores, typically in independent Vault clusters.  Reads are made from the primary, failing over to the
+// secondaries in turn whilst the primary is unavailable.  Writes are only made whilst the primary is available.
+type ReplicatedStore struct {
+	primary     *Store
+	secondaries []*Store
+	async       bool
+
+	// closeMu guards closed and the queues, which are closed with the store.
+	closeMu sync.RWMutex
+	closed  bool
+	queues  []chan *replicatedWrite
+	workers sync.WaitGroup
+
+	// pendingMu guards pending, the number of queued writes yet to be applied to a secondary, and failures, the
+	// number of writes that could not be replicated to each secondary.
+	pendingMu sync.Mutex
+	pending   int
+	failures  []int
+	flushed   *sync.Cond
+}
+
+// replicatedWrite is a write to be applied to a secondary store.
+type replicatedWrite struct {
+	operation string
+	apply     func(*Store) error
+}
+
+// ReplicationError is returned when a write was made to the primary store but could not be replicated to all of
+// the secondary stores.
+type ReplicationError struct {
+	// Operation is the write that was not replicated.
+	Operation string
+	// Errors are the errors from each secondary, in the order the secondaries were given; nil if the write was
+	// replicated to that secondary.
+	Errors []error
+}
+
+func (e *ReplicationError) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e.Errors {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("failed to replicate %s to %d of %d secondaries: %v", e.Operation, failed, len(e.Errors), first)
+}
+
+// NewReplicatedStore creates a store that writes to the primary store and replicates to the secondary stores.
+// Secondaries may use different passphrases from the primary, as data is replicated before encryption.
+// The replicated store takes ownership of the stores, closing them when it is closed.
+func NewReplicatedStore(primary *Store, secondaries []*Store, opts ...ReplicationOption) (*ReplicatedStore, error) {
+	options := replicationOptions{}
+	for _, o := range opts {
+		o.apply(&options)
+	}
+	if primary == nil {
+		return nil, errors.New("no primary store specified")
+	}
+	if len(secondaries) == 0 {
+		return nil, errors.New("no secondary stores specified")
+	}
+	if options.async && options.queueSize <= 0 {
+		return nil, errors.New("replication queue size must be positive")
+	}
+
+	r := &ReplicatedStore{
+		primary:     primary,
+		secondaries: secondaries,
+		async:       options.async,
+		failures:    make([]int, len(secondaries)),
+	}
+	r.flushed = sync.NewCond(&r.pendingMu)
+	if r.async {
+		r.queues = make([]chan *replicatedWrite, len(secondaries))
+		for i := range secondaries {
+			r.queues[i] = make(chan *replicatedWrite, options.queueSize)
+			r.workers.Add(1)
+			go r.replicateQueue(i)
+		}
+	}
+	return r, nil
+}
+
+// Name returns the name of this store.
+func (r *ReplicatedStore) Name() string {
+	return r.primary.Name()
+}
+
+// Location returns the location of the primary store.
+func (r *ReplicatedStore) Location() string {
+	return r.primary.Location()
+}
+
+// StoreWallet stores wallet-level data in the primary store and replicates it to the secondaries.
+func (r *ReplicatedStore) StoreWallet(walletID uuid.UUID, walletName string, data []byte) error {
+	if err := r.primary.StoreWallet(walletID, walletName, data); err != nil {
+		return err
+	}
+	// The write may be queued, so must not share the caller's data.
+	data = append([]byte{}, data...)
+	return r.replicate("store wallet", func(s *Store) error {
+		return s.StoreWallet(walletID, walletName, data)
+	})
+}
+
+// StoreAccount stores account-level data in the primary store and replicates it to the secondaries.
+func (r *ReplicatedStore) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	if err := r.primary.StoreAccount(walletID, accountID, data); err != nil {
+		return err
+	}
+	// The write may be queued, so must not share the caller's data.
+	data = append([]byte{}, data...)
+	return r.replicate("store account", func(s *Store) error {
+		return s.StoreAccount(walletID, accountID, data)
+	})
+}
+
+// StoreAccountsIndex stores the accounts index in the primary store and replicates it to the secondaries.
+func (r *ReplicatedStore) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
+	if err := r.primary.StoreAccountsIndex(walletID, data); err != nil {
+		return err
+	}
+	// The write may be queued, so must not share the caller's data.
+	data = append([]byte{}, data...)
+	return r.replicate("store accounts index", func(s *Store) error {
+		return s.StoreAccountsIndex(walletID, data)
+	})
+}
+
+// DeleteWallet deletes a wallet from the primary store and the secondaries.
+// A secondary that does not hold the wallet is treated as having deleted it.
+func (r *ReplicatedStore) DeleteWallet(walletID uuid.UUID) error {
+	if err := r.primary.DeleteWallet(walletID); err != nil {
+		return err
+	}
+	return r.replicate("delete wallet", func(s *Store) error {
+		return ignoreNotFound(s.DeleteWallet(walletID))
+	})
+}
+
+// DeleteAccount deletes an account from the primary store and the secondaries.
+// A secondary that does not hold the account is treated as having deleted it.
+func (r *ReplicatedStore) DeleteAccount(walletID uuid.UUID, accountID uuid.UUID) error {
+	if err := r.primary.DeleteAccount(walletID, accountID); err != nil {
+		return err
+	}
+	return r.replicate("delete account", func(s *Store) error {
+		return ignoreNotFound(s.DeleteAccount(walletID, accountID))
+	})
+}
+
+// RetrieveWallet retrieves wallet-level data.  It will fail if it cannot retrieve the data.
+func (r *ReplicatedStore) RetrieveWallet(walletName string) ([]byte, error) {
+	var data []byte
+	err := r.read("retrieve wallet", func(s *Store) error {
+		var err error
+		data, err = s.RetrieveWallet(walletName)
+		return notFoundOrUnavailable(s, err)
+	})
+	return data, err
+}
+
+// RetrieveWalletByID retrieves wallet-level data.  It will fail if it cannot retrieve the data.
+func (r *ReplicatedStore) RetrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
+	var data []byte
+	err := r.read("retrieve wallet by ID", func(s *Store) error {
+		var err error
+		data, err = s.RetrieveWalletByID(walletID)
+		return notFoundOrUnavailable(s, err)
+	})
+	return data, err
+}
+
+// RetrieveWallets retrieves wallet-level data for all wallets.
+func (r *ReplicatedStore) RetrieveWallets() <-chan []byte {
+	return r.readAll("retrieve wallets", func(s *Store) <-chan []byte {
+		return s.RetrieveWallets()
+	})
+}
+
+// RetrieveAccount retrieves account-level data.  It will fail if it cannot retrieve the data.
+func (r *ReplicatedStore) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte, error) {
+	var data []byte
+	err := r.read("retrieve account", func(s *Store) error {
+		var err error
+		data, err = s.RetrieveAccount(walletID, accountID)
+		return err
+	})
+	return data, err
+}
+
+// RetrieveAccounts retrieves all account-level data for a wallet.
+func (r *ReplicatedStore) RetrieveAccounts(walletID uuid.UUID) <-chan []byte {
+	return r.readAll("retrieve accounts", func(s *Store) <-chan []byte {
+		return s.RetrieveAccounts(walletID)
+	})
+}
+
+// RetrieveAccountsIndex retrieves the accounts index.
+func (r *ReplicatedStore) RetrieveAccountsIndex(walletID uuid.UUID) ([]byte, error) {
+	var data []byte
+	err := r.read("retrieve accounts index", func(s *Store) error {
+		var err error
+		data, err = s.RetrieveAccountsIndex(walletID)
+		return err
+	})
+	return data, err
+}
+
+// ReplicationFailures returns the number of writes that could not be replicated to each secondary, in the order the
+// secondaries were given.  With asynchronous replication this is the only report of failures other than the log.
+func (r *ReplicatedStore) ReplicationFailures() []int {
+	r.pendingMu.Lock()
+	defer r.pendingMu.Unlock()
+	return append([]int{}, r.failures...)
+}
+
+// Flush waits until all writes queued for replication have been applied to the secondaries.
+// It returns immediately if replication is synchronous.
+func (r *ReplicatedStore) Flush() {
+	r.pendingMu.Lock()
+	defer r.pendingMu.Unlock()
+	for r.pending > 0 {
+		r.flushed.Wait()
+	}
+}
+
+// Close applies any writes queued for replication and then closes the primary and secondary stores.
+// Closing a closed store has no effect.
+func (r *ReplicatedStore) Close() error {
+	r.closeMu.Lock()
+	if r.closed {
+		r.closeMu.Unlock()
+		return nil
+	}
+	r.closed = true
+	for _, queue := range r.queues {
+		close(queue)
+	}
+	r.closeMu.Unlock()
+	r.workers.Wait()
+
+	var res error
+	for _, s := range append([]*Store{r.primary}, r.secondaries...) {
+		if err := s.Close(); err != nil && res == nil {
+			res = errors.Wrap(err, fmt.Sprintf("failed to close store at %s", s.Location()))
+		}
+	}
+	return res
+}
+
+// replicate applies a write made to the primary store to each secondary, or queues it to be applied if replication
+// is asynchronous.
+func (r *ReplicatedStore) replicate(operation string, apply func(*Store) error) error {
+	write := &replicatedWrite{
+		operation: operation,
+		apply:     apply,
+	}
+	if r.async {
+		r.closeMu.RLock()
+		defer r.closeMu.RUnlock()
+		if r.closed {
+			return errClosed
+		}
+		r.pendingMu.Lock()
+		r.pending += len(r.queues)
+		r.pendingMu.Unlock()
+		for _, queue := range r.queues {
+			queue <- write
+		}
+		return nil
+	}
+
+	errs := make([]error, len(r.secondaries))
+	failed := false
+	for i, secondary := range r.secondaries {
+		errs[i] = write.apply(secondary)
+		if errs[i] != nil {
+			r.primary.logger.Warn("failed to replicate write", "operation", operation, "secondary", secondary.Location(), "error", errs[i])
+			r.pendingMu.Lock()
+			r.failures[i]++
+			r.pendingMu.Unlock()
+			failed = true
+		}
+	}
+	if failed {
+		return &ReplicationError{Operation: operation, Errors: errs}
+	}
+	return nil
+}
+
+// replicateQueue applies the writes queued for a secondary until the queue is closed.
+func (r *ReplicatedStore) replicateQueue(index int) {
+	defer r.workers.Done()
+	secondary := r.secondaries[index]
+	for write := range r.queues[index] {
+		err := write.apply(secondary)
+		if err != nil {
+			r.primary.logger.Warn("failed to replicate write", "operation", write.operation, "secondary", secondary.Location(), "error", err)
+		}
+		r.pendingMu.Lock()
+		if err != nil {
+			r.failures[index]++
+		}
+		r.pending--
+		if r.pending == 0 {
+			r.flushed.Broadcast()
+		}
+		r.pendingMu.Unlock()
+	}
+}
+
+// read calls read with the primary store and, whilst the store it was called with is unavailable, with each
+// secondary in turn.  It returns the error from the last store read.
+func (r *ReplicatedStore) read(operation string, read func(*Store) error) error {
+	err := read(r.primary)
+	for _, secondary := range r.secondaries {
+		if !unavailable(err) {
+			break
+		}
+		r.primary.logger.Warn("store unavailable, reading from secondary", "operation", operation, "secondary", secondary.Location(), "error", err)
+		err = read(secondary)
+	}
+	return err
+}
+
+// readAll streams the data retrieved from the first available store.
+// Retrieval of all wallets or accounts does not report failures, so a store that returns nothing is checked to
+// see if it could be reached.
+func (r *ReplicatedStore) readAll(operation string, retrieve func(*Store) <-chan []byte) <-chan []byte {
+	ch := make(chan []byte, 1024)
+	go func() {
+		defer close(ch)
+		_ = r.read(operation, func(s *Store) error {
+			found := false
+			for data := range retrieve(s) {
+				found = true
+				ch <- data
+			}
+			if found {
+				return nil
+			}
+			return reachable(s)
+		})
+	}()
+	return ch
+}
+
+// notFoundOrUnavailable returns the error from a store that reported data as not found, replacing it with the
+// reason that the store is unavailable if it cannot be reached.  Retrieval of wallets lists them first, and does not
+// report failures to do so.
+func notFoundOrUnavailable(s *Store, err error) error {
+	if errorType(err) != ErrorTypeNotFound {
+		return err
+	}
+	if reachableErr := reachable(s); unavailable(reachableErr) {
+		return reachableErr
+	}
+	return err
+}
+
+// reachable returns an error if the store's wallets cannot be listed.
+func reachable(s *Store) error {
+	_, err := s.kvList(context.Background(), "wallets")
+	return err
+}
+
+// unavailable returns true if the error shows that a store could not be reached.
+func unavailable(err error) bool {
+	switch errorType(err) {
+	case ErrorTypeUnavailable, ErrorTypeTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// ignoreNotFound returns nil if the error is that data was not found, otherwise the error.
+func ignoreNotFound(err error) error {
+	if errorType(err) == ErrorTypeNotFound {
+		return nil
+	}
+	return err
+}
+
+// InconsistencyStatus is how the data held by a secondary store differs from that held by the primary.
+type InconsistencyStatus string
+
+const (
+	// InconsistencyMissing is data held by the primary but not the secondary.
+	InconsistencyMissing InconsistencyStatus = "missing"
+	// InconsistencyExtra is data held by the secondary but not the primary.
+	InconsistencyExtra InconsistencyStatus = "extra"
+	// InconsistencyDifferent is data that differs between the primary and the secondary.
+	InconsistencyDifferent InconsistencyStatus = "different"
+	// InconsistencyUnreadable is data that the primary or the secondary holds but cannot retrieve or decrypt.
+	InconsistencyUnreadable InconsistencyStatus = "unreadable"
+)
+
+// Inconsistency is a difference between the data held by the primary store and a secondary.
+type Inconsistency struct {
+	// Secondary is the index of the secondary, in the order the secondaries were given.
+	Secondary int
+	// Type is "wallet", "account" or "index".
+	Type      string
+	WalletID  uuid.UUID
+	AccountID uuid.UUID
+	Status    InconsistencyStatus
+}
+
+// ConsistencyReport is the result of checking the consistency of the secondary stores with the primary.
+type ConsistencyReport struct {
+	// Checked is the number of wallets, accounts and accounts indices held by the primary.
+	Checked int
+	// Inconsistencies are the differences found, by secondary.
+	Inconsistencies []*Inconsistency
+}
+
+// Consistent returns true if all secondaries hold the same data as the primary.
+func (r *ConsistencyReport) Consistent() bool {
+	return len(r.Inconsistencies) == 0
+}
+
+// CheckConsistency compares the wallets, accounts and accounts indices held by each secondary store with those held
+// by the primary, without modifying anything.  Data is compared once decrypted, so stores with different passphrases
+// can be compared; each secret is decrypted only whilst it is compared.  It returns an error if the wallets or
+// accounts of any store cannot be listed.
+// Missing data can be copied from the primary to a secondary with Migrate.
+func (r *ReplicatedStore) CheckConsistency(ctx context.Context) (*ConsistencyReport, error) {
+	primary, err := secretPaths(ctx, r.primary)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read primary store")
+	}
+	secondaries := make([]map[secretBinding]string, len(r.secondaries))
+	for i, s := range r.secondaries {
+		secondaries[i], err = secretPaths(ctx, s)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to read secondary store at %s", s.Location()))
+		}
+	}
+
+	report := &ConsistencyReport{
+		Inconsistencies: make([]*Inconsistency, 0),
+	}
+	for binding, path := range primary {
+		binding := binding
+		primaryData, primaryExists := snapshotSecret(ctx, r.primary, path, &binding)
+		if primaryExists {
+			report.Checked++
+		}
+		for i, s := range r.secondaries {
+			var secondaryData []byte
+			secondaryExists := false
+			if secondaryPath, listed := secondaries[i][binding]; listed {
+				secondaryData, secondaryExists = snapshotSecret(ctx, s, secondaryPath, &binding)
+			}
+			switch {
+			case !primaryExists && !secondaryExists:
+			case !primaryExists:
+				report.add(i, binding, InconsistencyExtra)
+			case !secondaryExists:
+				report.add(i, binding, InconsistencyMissing)
+			case primaryData == nil || secondaryData == nil:
+				report.add(i, binding, InconsistencyUnreadable)
+			case !bytes.Equal(primaryData, secondaryData):
+				report.add(i, binding, InconsistencyDifferent)
+			}
+			zero(secondaryData)
+		}
+		zero(primaryData)
+	}
+	for i, s := range r.secondaries {
+		for binding, path := range secondaries[i] {
+			if _, listed := primary[binding]; listed {
+				continue
+			}
+			binding := binding
+			data, exists := snapshotSecret(ctx, s, path, &binding)
+			zero(data)
+			if exists {
+				report.add(i, binding, InconsistencyExtra)
+			}
+		}
+	}
+	return report, nil
+}
+
+func (r *ConsistencyReport) add(secondary int, binding secretBinding, status InconsistencyStatus) {
+	r.Inconsistencies = append(r.Inconsistencies, &Inconsistency{
+		Secondary: secondary,
+		Type:      binding.kind,
+		WalletID:  binding.walletID,
+		AccountID: binding.accountID,
+		Status:    status,
+	})
+}
+
+// secretPaths returns the path of each wallet, account and accounts index in the store.  Accounts indices are
+// included whether or not they exist.
+func secretPaths(ctx context.Context, s *Store) (map[secretBinding]string, error) {
+	res := make(map[secretBinding]string)
+	err := s.walkSecrets(ctx, func(ctx context.Context, path string, binding *secretBinding) error {
+		if binding.kind == secretKindPublicKeyIndex {
+			// Maintained by each store from its own accounts.
+			return nil
+		}
+		res[*binding] = path
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// snapshotSecret returns the decrypted data of a secret, or nil if it cannot be retrieved or decrypted, and whether it
+// exists.  The caller should zero the data once it has been used.
+func snapshotSecret(ctx context.Context, s *Store, path string, binding *secretBinding) ([]byte, bool) {
+	secret, err := s.kvGet(ctx, path)
+	if errors.Is(err, vault.ErrSecretNotFound) {
+		// No index, or removed since listing.
+		return nil, false
+	}
+	if err != nil {
+		return nil, true
+	}
+	data, err := s.openSecret(secret, binding)
+	if err != nil {
+		return nil, true
+	}
+	return data, true
+}
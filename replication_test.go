@@ -0,0 +1,170 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newReplicaStore creates a store on a fresh KV version 2 mount at the given address.
+func newReplicaStore(t *testing.T, addr string, passphrase string) *vault.Store {
+	mount := fmt.Sprintf("replica-%s", uuid.New())
+	mountKV(t, mount, "2")
+	store, err := vault.New(
+		vault.WithPassphrase([]byte(passphrase)),
+		vault.WithVaultAddr(addr),
+		vault.WithVaultSecretMountPath(mount),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	require.Nil(t, err)
+	return store.(*vault.Store)
+}
+
+func TestReplicatedStore(t *testing.T) {
+	// Fail fast when the primary is down.
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+	proxy := newProxyVault(t)
+	primary := newReplicaStore(t, proxy.URL, "primary")
+	secondary := newReplicaStore(t, "http://localhost:8200", "secondary")
+	store, err := vault.NewReplicatedStore(primary, []*vault.Store{secondary})
+	require.Nil(t, err)
+	defer store.Close()
+
+	walletID := uuid.New()
+	walletData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"replicated wallet"}`, walletID))
+	require.Nil(t, store.StoreWallet(walletID, "replicated wallet", walletData))
+	accountID := uuid.New()
+	accountData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"replicated account"}`, accountID))
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData))
+	require.Nil(t, store.StoreAccountsIndex(walletID, []byte(`{}`)))
+
+	// Writes are replicated to the secondary.
+	data, err := secondary.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, accountData, data)
+	report, err := store.CheckConsistency(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, 3, report.Checked)
+	assert.True(t, report.Consistent())
+
+	// Reads fail over to the secondary whilst the primary is unavailable.
+	atomic.StoreInt32(&proxy.down, 1)
+	data, err = store.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, accountData, data)
+	data, err = store.RetrieveWalletByID(walletID)
+	require.Nil(t, err)
+	assert.Equal(t, walletData, data)
+	accounts := 0
+	for range store.RetrieveAccounts(walletID) {
+		accounts++
+	}
+	assert.Equal(t, 1, accounts)
+	_, err = store.RetrieveAccount(walletID, uuid.New())
+	assert.NotNil(t, err)
+	// Writes are not.
+	assert.NotNil(t, store.StoreAccount(walletID, uuid.New(), accountData))
+	atomic.StoreInt32(&proxy.down, 0)
+
+	// Differences between the stores are reported.
+	otherAccountID := uuid.New()
+	require.Nil(t, primary.StoreAccount(walletID, otherAccountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"primary account"}`, otherAccountID))))
+	require.Nil(t, secondary.StoreAccountsIndex(walletID, []byte(`{"changed":true}`)))
+	report, err = store.CheckConsistency(context.Background())
+	require.Nil(t, err)
+	require.Len(t, report.Inconsistencies, 2)
+	statuses := make(map[vault.InconsistencyStatus]*vault.Inconsistency)
+	for _, inconsistency := range report.Inconsistencies {
+		statuses[inconsistency.Status] = inconsistency
+	}
+	require.NotNil(t, statuses[vault.InconsistencyMissing])
+	assert.Equal(t, "account", statuses[vault.InconsistencyMissing].Type)
+	assert.Equal(t, otherAccountID, statuses[vault.InconsistencyMissing].AccountID)
+	require.NotNil(t, statuses[vault.InconsistencyDifferent])
+	assert.Equal(t, "index", statuses[vault.InconsistencyDifferent].Type)
+
+	// Deletes are replicated, including to secondaries without the data.
+	require.Nil(t, store.DeleteAccount(walletID, otherAccountID))
+	require.Nil(t, store.DeleteAccount(walletID, accountID))
+	_, err = secondary.RetrieveAccount(walletID, accountID)
+	assert.NotNil(t, err)
+}
+
+func TestReplicatedStoreAsync(t *testing.T) {
+	// Fail fast when a secondary is down.
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+	proxy := newProxyVault(t)
+	primary := newReplicaStore(t, "http://localhost:8200", "primary")
+	secondaries := []*vault.Store{
+		newReplicaStore(t, "http://localhost:8200", "secondary"),
+		newReplicaStore(t, proxy.URL, "other"),
+	}
+	store, err := vault.NewReplicatedStore(primary, secondaries, vault.WithAsyncReplication(16))
+	require.Nil(t, err)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "async wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"async wallet"}`, walletID))))
+	accountIDs := make([]uuid.UUID, 4)
+	for i := range accountIDs {
+		accountIDs[i] = uuid.New()
+		data := []byte(fmt.Sprintf(`{"uuid":%q,"name":"async account %d"}`, accountIDs[i], i))
+		require.Nil(t, store.StoreAccount(walletID, accountIDs[i], data))
+		// Queued writes do not share the caller's data.
+		for j := range data {
+			data[j] = 0
+		}
+	}
+
+	store.Flush()
+	for _, secondary := range secondaries {
+		for _, accountID := range accountIDs {
+			_, err := secondary.RetrieveAccount(walletID, accountID)
+			require.Nil(t, err)
+		}
+	}
+	report, err := store.CheckConsistency(context.Background())
+	require.Nil(t, err)
+	assert.True(t, report.Consistent())
+	assert.Equal(t, []int{0, 0}, store.ReplicationFailures())
+
+	// Failed writes are counted by secondary.
+	atomic.StoreInt32(&proxy.down, 1)
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"unreplicated account"}`, accountID))))
+	store.Flush()
+	assert.Equal(t, []int{0, 1}, store.ReplicationFailures())
+	atomic.StoreInt32(&proxy.down, 0)
+
+	require.Nil(t, store.Close())
+	assert.NotNil(t, store.StoreWallet(walletID, "async wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"async wallet"}`, walletID))))
+}
+
+func TestNewReplicatedStoreBadParams(t *testing.T) {
+	primary := newReplicaStore(t, "http://localhost:8200", "primary")
+	_, err := vault.NewReplicatedStore(primary, nil)
+	assert.NotNil(t, err)
+	_, err = vault.NewReplicatedStore(nil, []*vault.Store{primary})
+	assert.NotNil(t, err)
+	_, err = vault.NewReplicatedStore(primary, []*vault.Store{primary}, vault.WithAsyncReplication(0))
+	assert.NotNil(t, err)
+}
@@ -16,24 +16,44 @@ package vaultstorage
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"os"
+	"sync"
 
 	vault "github.com/hashicorp/vault/api"
-	auth "github.com/hashicorp/vault/api/auth/kubernetes"
-
 	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // options are the options for the S3 store
 type options struct {
 	id                           []byte
 	vault_addr                   string
+	vault_namespace              string
+	vault_cacert                 string
+	vault_read_addrs             []string
 	vault_auth                   string
+	auth_method                  AuthMethod
 	vault_token                  string
 	vault_k8s_auth_role          string
 	vault_k8s_auth_sa_token_path string
 	vault_k8s_auth_mount_path    string
 	vault_secrets_mount_path     string
+	vault_kv_version             int
+	account_layout               string
+	custom_metadata              bool
+	require_binding              bool
+	strict_retrieval             bool
+	lock_memory                  bool
+	metrics                      Metrics
+	tracer_provider              trace.TracerProvider
+	logger                       Logger
+	redact_logs                  bool
+	audit_sink                   AuditSink
+	audit_file                   string
+	preflight                    bool
+	read_only                    bool
 	passphrase                   []byte
 }
 
@@ -69,6 +89,28 @@ func WithVaultAddr(t string) Option {
 	})
 }
 
+// WithVaultNamespace sets the Vault namespace for the store
+func WithVaultNamespace(t string) Option {
+	return optionFunc(func(o *options) {
+		o.vault_namespace = t
+	})
+}
+
+// WithVaultCACert sets the path to the CA certificate used to verify the Vault server
+func WithVaultCACert(t string) Option {
+	return optionFunc(func(o *options) {
+		o.vault_cacert = t
+	})
+}
+
+// WithVaultReadAddrs sets the addresses of Vault performance standbys or replicas from which to read.
+// Writes are always made to the address set with WithVaultAddr.
+func WithVaultReadAddrs(addrs []string) Option {
+	return optionFunc(func(o *options) {
+		o.vault_read_addrs = addrs
+	})
+}
+
 // WithID sets the ID for the store
 func WithVaultAuth(t string) Option {
 	return optionFunc(func(o *options) {
@@ -76,6 +118,14 @@ func WithVaultAuth(t string) Option {
 	})
 }
 
+// WithAuthMethod sets the method used to authenticate with Vault.
+// This takes precedence over the authentication type set with WithVaultAuth().
+func WithAuthMethod(t AuthMethod) Option {
+	return optionFunc(func(o *options) {
+		o.auth_method = t
+	})
+}
+
 // WithID sets the ID for the store
 func WithVaultToken(t string) Option {
 	return optionFunc(func(o *options) {
@@ -111,9 +161,137 @@ func WithVaultSecretMountPath(t string) Option {
 	})
 }
 
+// WithVaultKVVersion sets the version of the KV secrets engine for the store.
+// If this is not set the version is detected from the secrets engine mount.
+func WithVaultKVVersion(t int) Option {
+	return optionFunc(func(o *options) {
+		o.vault_kv_version = t
+	})
+}
+
+// WithAccountLayout sets the layout of accounts within their wallet, either AccountLayoutFlat or AccountLayoutSharded.
+// If this is not set the flat layout is used.
+func WithAccountLayout(layout string) Option {
+	return optionFunc(func(o *options) {
+		o.account_layout = layout
+	})
+}
+
+// WithCustomMetadata writes non-secret attributes of wallets and accounts, such as their names and public keys, to
+// the KV version 2 custom metadata of each secret, so that they are visible without decrypting.
+func WithCustomMetadata() Option {
+	return optionFunc(func(o *options) {
+		o.custom_metadata = true
+	})
+}
+
+// WithRequireBinding refuses encrypted secrets that are not bound to their wallet, account and store, as written by
+// earlier versions of the store.  Without it such secrets are still read, with a warning, but could have been copied
+// from elsewhere.  It should be set once UpgradeEnvelopes has rewritten them and Verify reports none as unbound.
+func WithRequireBinding() Option {
+	return optionFunc(func(o *options) {
+		o.require_binding = true
+	})
+}
+
+// WithStrictRetrieval fails retrieval of wallets and accounts that cannot be read or decrypted, rather than skipping
+// them.  Paged listings return an error.  Retrieval of all wallets or accounts has no means to return an error, so it
+// logs the error and stops, closing the channel early; callers that need the error should use the paged listings.
+func WithStrictRetrieval() Option {
+	return optionFunc(func(o *options) {
+		o.strict_retrieval = true
+	})
+}
+
+// WithLockedMemory holds the store's copy of its passphrase in memory that is locked so that it is never swapped to
+// disk, and excluded from core dumps.  It is only supported on Linux, and is subject to the process's limit on locked
+// memory.
+func WithLockedMemory() Option {
+	return optionFunc(func(o *options) {
+		o.lock_memory = true
+	})
+}
+
+// WithMetrics records measurements of the store's operations, Vault requests and token renewals.
+func WithMetrics(metrics Metrics) Option {
+	return optionFunc(func(o *options) {
+		o.metrics = metrics
+	})
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider with which spans are created for the store's operations
+// and Vault requests.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return optionFunc(func(o *options) {
+		o.tracer_provider = provider
+	})
+}
+
+// WithLogger sets the logger to which the store logs authentication, token renewal, skipped secrets and retries.
+func WithLogger(logger Logger) Option {
+	return optionFunc(func(o *options) {
+		o.logger = logger
+	})
+}
+
+// WithLogRedaction removes wallet and account IDs, and the paths containing them, from the store's logs.
+// Vault tokens are always removed.
+func WithLogRedaction() Option {
+	return optionFunc(func(o *options) {
+		o.redact_logs = true
+	})
+}
+
+// WithAuditSink records every read and write of a wallet or account with the given sink.
+func WithAuditSink(sink AuditSink) Option {
+	return optionFunc(func(o *options) {
+		o.audit_sink = sink
+	})
+}
+
+// WithAuditFile appends a JSON line to the given file for every read and write of a wallet or account.
+func WithAuditFile(path string) Option {
+	return optionFunc(func(o *options) {
+		o.audit_file = path
+	})
+}
+
+// WithPreflight checks at creation that the Vault token has all of the capabilities required by the store.
+func WithPreflight() Option {
+	return optionFunc(func(o *options) {
+		o.preflight = true
+	})
+}
+
+// WithReadOnly makes the store read-only.  Attempts to store data will fail with a ReadOnlyError without contacting Vault,
+// and preflight checks only for the capabilities required to read.
+func WithReadOnly() Option {
+	return optionFunc(func(o *options) {
+		o.read_only = true
+	})
+}
+
+var (
+	errWalletNotFound  = errors.New("wallet not found")
+	errAccountNotFound = errors.New("account not found")
+)
+
+// ReadOnlyError is returned when attempting to write to a read-only store.
+type ReadOnlyError struct {
+	Operation string
+}
+
+// Error implements the error interface.
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("store is read-only; cannot %s", e.Operation)
+}
+
 // Store is the store for the wallet held encrypted on Amazon S3.
 type Store struct {
 	client                       *vault.Client
+	readClients                  []*vault.Client
+	readNext                     uint32
+	auth                         AuthMethod
 	id                           []byte
 	vault_addr                   string
 	vault_auth                   string
@@ -122,13 +300,55 @@ type Store struct {
 	vault_k8s_auth_sa_token_path string
 	vault_k8s_auth_mount_path    string
 	vault_secrets_mount_path     string
+	vault_kv_version             int
+	account_layout               string
+	custom_metadata              bool
+	require_binding              bool
+	strict_retrieval             bool
+	read_only                    bool
 	passphrase                   []byte
+	metrics                      Metrics
+	tracer                       trace.Tracer
+	logger                       Logger
+	audit_sink                   AuditSink
+	auditFile                    *os.File
+	host                         string
+
+	// pubKeyIndexMu serialises updates to the public key index made by the store.
+	pubKeyIndexMu sync.Mutex
+
+	// accessorMu guards the accessor of the Vault token, which changes when the store logs in again.
+	accessorMu sync.RWMutex
+	accessor   string
+
+	// keyMu guards use of the passphrase against the store being closed.
+	keyMu             sync.RWMutex
+	closed            bool
+	releasePassphrase func() error
+	done              chan struct{}
+	// maintenance tracks the goroutine that maintains the Vault token, so that closing waits for it.
+	maintenance sync.WaitGroup
 }
 
 // New creates a new Amazon S3 store.
 // This takes the following options:
 //   - region: a string specifying the Amazon S3 region, defaults to "us-east-1", set with WithRegion()
 //   - id: a byte array specifying an identifying key for the store, defaults to nil, set with WithID()
+//   - vault_read_addrs: the addresses of Vault performance standbys or replicas from which to read, defaults to none, set with WithVaultReadAddrs()
+//   - vault_kv_version: the version of the KV secrets engine, detected from the mount if not set with WithVaultKVVersion()
+//   - account_layout: the layout of accounts within their wallet, defaults to "flat", set with WithAccountLayout()
+//   - custom_metadata: write non-secret attributes to KV version 2 custom metadata, defaults to false, set with WithCustomMetadata()
+//   - require_binding: refuse encrypted secrets not bound to their location, defaults to false, set with WithRequireBinding()
+//   - strict_retrieval: fail rather than skip wallets and accounts that cannot be read, defaults to false, set with WithStrictRetrieval()
+//   - lock_memory: lock the passphrase in to memory, defaults to false, set with WithLockedMemory()
+//   - metrics: record measurements of store operations, defaults to none, set with WithMetrics()
+//   - tracer_provider: create spans for store operations, defaults to the global tracer provider, set with WithTracerProvider()
+//   - logger: log authentication, token renewal, skipped secrets and retries, defaults to warnings and errors with the standard logger, set with WithLogger()
+//   - redact_logs: remove wallet and account IDs from logs, defaults to false, set with WithLogRedaction()
+//   - audit_sink: record every read and write of a wallet or account, defaults to none, set with WithAuditSink()
+//   - audit_file: append every read and write of a wallet or account to a JSON-lines file, defaults to none, set with WithAuditFile()
+//   - read_only: refuse to store data, defaults to false, set with WithReadOnly()
+//   - preflight: check the Vault token has the capabilities required by the store, defaults to false, set with WithPreflight()
 //
 // This expects the access credentials to be in a standard place, e.g. ~/.aws/credentials
 func New(opts ...Option) (wtypes.Store, error) {
@@ -140,6 +360,8 @@ func New(opts ...Option) (wtypes.Store, error) {
 		vault_k8s_auth_sa_token_path: "/var/run/secrets/kubernetes.io/serviceaccount/token",
 		vault_k8s_auth_mount_path:    "kubernetes",
 		vault_secrets_mount_path:     "",
+		account_layout:               AccountLayoutFlat,
+		metrics:                      nullMetrics{},
 	}
 	for _, o := range opts {
 		o.apply(&options)
@@ -149,70 +371,79 @@ func New(opts ...Option) (wtypes.Store, error) {
 		return nil, errors.New("vault_addr option missing")
 	}
 
-	if options.vault_auth == "" {
-		return nil, errors.New("vault_auth option missing")
+	if options.metrics == nil {
+		options.metrics = nullMetrics{}
+	}
+	if options.tracer_provider == nil {
+		options.tracer_provider = otel.GetTracerProvider()
+	}
+	if options.logger == nil {
+		options.logger = stdLogger{}
+	}
+	if options.audit_sink != nil && options.audit_file != "" {
+		return nil, errors.New("audit_sink and audit_file options cannot both be set")
+	}
+
+	authMethod, err := authMethodFromOptions(&options)
+	if err != nil {
+		return nil, err
 	}
 
 	if options.vault_secrets_mount_path == "" {
 		return nil, errors.New("vault_secrets_mount_path option missing")
 	}
 
-	if options.vault_auth == "token" && options.vault_token == "" {
-		return nil, errors.New("vault_token option missing")
+	if options.vault_kv_version != 0 && options.vault_kv_version != 1 && options.vault_kv_version != 2 {
+		return nil, errors.New("vault_kv_version option must be 1 or 2")
 	}
 
-	if options.vault_auth == "kubernetes" && options.vault_k8s_auth_role == "" {
-		return nil, errors.New("vault_k8s_auth_role option missing")
+	if options.account_layout != AccountLayoutFlat && options.account_layout != AccountLayoutSharded {
+		return nil, fmt.Errorf("account_layout option must be %q or %q", AccountLayoutFlat, AccountLayoutSharded)
 	}
 
 	// If set, the VAULT_ADDR environment variable will be the address that
 	// your pod uses to communicate with Vault.
 	config := vault.DefaultConfig() // modify for more granular configuration
 	config.Address = options.vault_addr
+	if options.vault_cacert != "" {
+		if err := config.ConfigureTLS(&vault.TLSConfig{CACert: options.vault_cacert}); err != nil {
+			return nil, err
+		}
+	}
 
 	client, err := vault.NewClient(config)
 	if err != nil {
 		return nil, err
 	}
-
-	if options.vault_auth == "token" {
-		client.SetToken(options.vault_token)
+	if options.vault_namespace != "" {
+		client.SetNamespace(options.vault_namespace)
 	}
+	recordAttempts(client)
 
-	if options.vault_auth == "kubernetes" {
-		// The service-account token will be read from the path where the token's
-		// Kubernetes Secret is mounted. By default, Kubernetes will mount it to
-		// /var/run/secrets/kubernetes.io/serviceaccount/token, but an administrator
-		// may have configured it to be mounted elsewhere.
-		// In that case, we'll use the option WithServiceAccountTokenPath to look
-		// for the token there.
-		k8sAuth, err := auth.NewKubernetesAuth(
-			options.vault_k8s_auth_role,
-			auth.WithMountPath(options.vault_k8s_auth_mount_path),
-			auth.WithServiceAccountTokenPath(options.vault_k8s_auth_sa_token_path),
-		)
+	// Read clients share the client's configuration, including its retry policy, and its record of the latest
+	// replication state it has seen, which is sent with each request so that reads include earlier writes.
+	var readClients []*vault.Client
+	if len(options.vault_read_addrs) > 0 {
+		client.SetReadYourWrites(true)
+	}
+	for _, addr := range options.vault_read_addrs {
+		readClient, err := client.Clone()
 		if err != nil {
 			return nil, err
 		}
-
-		authInfo, err := client.Auth().Login(context.Background(), k8sAuth)
-		if err != nil {
-			return nil, err
+		if err := readClient.SetAddress(addr); err != nil {
+			return nil, fmt.Errorf("vault_read_addrs option has invalid address %q: %v", addr, err)
 		}
-		if authInfo == nil {
-			log.Fatal("no auth info was returned after login")
+		if options.vault_namespace != "" {
+			readClient.SetNamespace(options.vault_namespace)
 		}
-		client.SetToken(authInfo.Auth.ClientToken)
+		readClients = append(readClients, readClient)
 	}
 
-	endpoint := "/" + options.vault_secrets_mount_path + "/metadata/wallets"
-	_, err = client.Logical().List(endpoint)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Store{
+	store := &Store{
 		client:                       client,
+		readClients:                  readClients,
+		auth:                         authMethod,
 		id:                           options.id,
 		vault_addr:                   options.vault_addr,
 		vault_auth:                   options.vault_auth,
@@ -221,8 +452,69 @@ func New(opts ...Option) (wtypes.Store, error) {
 		vault_k8s_auth_sa_token_path: options.vault_k8s_auth_sa_token_path,
 		vault_k8s_auth_mount_path:    options.vault_k8s_auth_mount_path,
 		vault_secrets_mount_path:     options.vault_secrets_mount_path,
-		passphrase:                   options.passphrase,
-	}, nil
+		vault_kv_version:             options.vault_kv_version,
+		account_layout:               options.account_layout,
+		custom_metadata:              options.custom_metadata,
+		require_binding:              options.require_binding,
+		strict_retrieval:             options.strict_retrieval,
+		read_only:                    options.read_only,
+		metrics:                      options.metrics,
+		tracer:                       options.tracer_provider.Tracer(tracerName),
+		logger:                       &redactingLogger{logger: options.logger, redact: options.redact_logs},
+		audit_sink:                   options.audit_sink,
+		done:                         make(chan struct{}),
+	}
+
+	authSecret, err := store.login(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if store.vault_kv_version == 0 {
+		store.vault_kv_version, err = detectKVVersion(context.Background(), client, store.vault_secrets_mount_path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if store.custom_metadata && store.vault_kv_version != 2 {
+		return nil, errors.New("custom_metadata option requires KV version 2")
+	}
+
+	if options.preflight {
+		if err := store.preflight(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = store.kvList(context.Background(), "wallets")
+	if err != nil {
+		return nil, err
+	}
+
+	if options.audit_file != "" {
+		store.auditFile, err = openAuditFile(options.audit_file)
+		if err != nil {
+			return nil, err
+		}
+		store.audit_sink = NewJSONLinesAuditSink(store.auditFile)
+	}
+	if store.audit_sink != nil {
+		store.host, _ = os.Hostname()
+		store.setTokenAccessor(context.Background(), authSecret)
+	}
+
+	store.passphrase, store.releasePassphrase, err = copyPassphrase(options.passphrase, options.lock_memory)
+	if err != nil {
+		if store.auditFile != nil {
+			store.auditFile.Close()
+		}
+		return nil, err
+	}
+
+	store.maintenance.Add(1)
+	go store.maintainToken(authSecret)
+
+	return store, nil
 }
 
 // Name returns the name of this store.
@@ -234,3 +526,19 @@ func (s *Store) Name() string {
 func (s *Store) Location() string {
 	return s.vault_addr
 }
+
+// checkWritable returns an error if the store is read-only.
+func (s *Store) checkWritable(operation string) error {
+	if s.read_only {
+		return &ReadOnlyError{Operation: operation}
+	}
+	return nil
+}
+
+// access returns the level of access the store requires.
+func (s *Store) access() PolicyAccess {
+	if s.read_only {
+		return PolicyReadOnly
+	}
+	return PolicyReadWriteDelete
+}
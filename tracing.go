@@ -0,0 +1,179 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer that creates the store's spans.
+const tracerName = "github.com/stake-capital/go-eth2-wallet-store-vault"
+
+// operation is a store operation in progress.
+type operation struct {
+	store   *Store
+	name    string
+	started time.Time
+	span    trace.Span
+	// requestID is the ID of the operation's latest Vault request, if Vault returned one.
+	requestID string
+}
+
+type operationKey struct{}
+
+// startOperation starts a store operation, returning a context that carries the operation and its span.
+func (s *Store) startOperation(ctx context.Context, name string) (context.Context, *operation) {
+	ctx, span := s.tracer.Start(ctx, "store."+name)
+	op := &operation{
+		store:   s,
+		name:    name,
+		started: time.Now(),
+		span:    span,
+	}
+	return context.WithValue(ctx, operationKey{}, op), op
+}
+
+// end ends the operation, recording its duration and error.
+// It is deferred with a pointer to the operation's error result.
+func (o *operation) end(err *error) {
+	o.store.metrics.ObserveOperation(o.name, time.Since(o.started), errorType(*err))
+	endSpan(o.span, *err)
+}
+
+// request is a Vault request in progress.
+type request struct {
+	store   *Store
+	op      *operation
+	name    string
+	path    string
+	started time.Time
+	span    trace.Span
+	attempt *requestAttempt
+	// requestID is the ID Vault returned for the request, set by the caller before ending the request.
+	requestID string
+}
+
+// requestAttempt is the outcome of the latest attempt at a request, recorded by the client as it retries.
+type requestAttempt struct {
+	statusCode int
+	retries    int
+}
+
+type requestAttemptKey struct{}
+
+// startRequest starts a Vault request to the given path, returning a context that carries the request's span and
+// should be passed to the client.  The path is recorded without the wallet and account IDs it contains.
+func (s *Store) startRequest(ctx context.Context, name string, path string) (context.Context, *request) {
+	attributes := []attribute.KeyValue{attribute.String("vault.request", name)}
+	if path != "" {
+		attributes = append(attributes, attribute.String("vault.path", pathTemplate(path)))
+	}
+	ctx, span := s.tracer.Start(ctx, "vault."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+	attempt := &requestAttempt{}
+	op, _ := ctx.Value(operationKey{}).(*operation)
+	return context.WithValue(ctx, requestAttemptKey{}, attempt), &request{
+		store:   s,
+		op:      op,
+		name:    name,
+		path:    path,
+		started: time.Now(),
+		span:    span,
+		attempt: attempt,
+	}
+}
+
+// end ends the request, recording its duration, retries and error.
+func (r *request) end(err error) {
+	if r.op != nil {
+		r.op.requestID = r.requestID
+	}
+	r.store.metrics.ObserveVaultRequest(r.name, time.Since(r.started), errorType(err))
+	for i := 0; i < r.attempt.retries; i++ {
+		r.store.metrics.Retry(r.name)
+	}
+	if r.attempt.retries > 0 {
+		r.store.logger.Warn("retried Vault request", "request", r.name, "path", r.path, "retries", r.attempt.retries)
+	}
+
+	statusCode := r.attempt.statusCode
+	var responseErr *vault.ResponseError
+	if errors.As(err, &responseErr) {
+		statusCode = responseErr.StatusCode
+	}
+	if statusCode != 0 {
+		r.span.SetAttributes(attribute.Int("http.status_code", statusCode))
+	}
+	r.span.SetAttributes(attribute.Int("vault.retries", r.attempt.retries))
+	endSpan(r.span, err)
+}
+
+// endSpan ends a span with the outcome of its operation.
+// Only the type of any error is recorded, as error messages can contain full paths.
+func endSpan(span trace.Span, err error) {
+	if errType := errorType(err); errType != "" {
+		span.SetAttributes(attribute.String("error.type", errType))
+		span.SetStatus(codes.Error, errType)
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+	span.End()
+}
+
+// recordAttempts wraps the client's retry policy to record the status and retries of each request in its context.
+func recordAttempts(client *vault.Client) {
+	checkRetry := client.CheckRetry()
+	if checkRetry == nil {
+		checkRetry = vault.DefaultRetryPolicy
+	}
+	maxRetries := client.MaxRetries()
+	client.SetCheckRetry(func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		retry, retryErr := checkRetry(ctx, resp, err)
+		if attempt, exists := ctx.Value(requestAttemptKey{}).(*requestAttempt); exists {
+			if resp != nil {
+				attempt.statusCode = resp.StatusCode
+			}
+			// The policy is also consulted after the final attempt, which is not retried.
+			if retry && attempt.retries < maxRetries {
+				attempt.retries++
+			}
+		}
+		return retry, retryErr
+	})
+}
+
+// pathTemplate returns a Vault path with the wallet and account IDs, and account shards, replaced by placeholders.
+func pathTemplate(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if _, err := uuid.Parse(part); err == nil {
+			parts[i] = "{id}"
+		} else if i > 0 && parts[i-1] == "accounts" {
+			parts[i] = "{shard}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
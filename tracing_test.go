@@ -0,0 +1,171 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordingTracer records the spans it starts.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return t
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parent, _ := trace.SpanFromContext(ctx).(*recordedSpan)
+	_, noop := trace.NewNoopTracerProvider().Tracer("").Start(ctx, name)
+	span := &recordedSpan{
+		Span:       noop,
+		tracer:     t,
+		name:       name,
+		parent:     parent,
+		attributes: make(map[attribute.Key]attribute.Value),
+	}
+	config := trace.NewSpanStartConfig(opts...)
+	span.SetAttributes(config.Attributes()...)
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// find returns the ended spans with the given name.
+func (t *recordingTracer) find(name string) []*recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]*recordedSpan, 0)
+	for _, span := range t.spans {
+		if span.name == name && span.ended {
+			res = append(res, span)
+		}
+	}
+	return res
+}
+
+// recordedSpan is a span with the attributes and status set on it.
+type recordedSpan struct {
+	trace.Span
+	tracer     *recordingTracer
+	name       string
+	parent     *recordedSpan
+	attributes map[attribute.Key]attribute.Value
+	status     codes.Code
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for _, attr := range kv {
+		s.attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.status = code
+}
+
+func (s *recordedSpan) End(...trace.SpanEndOption) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.ended = true
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+		vault.WithTracerProvider(tracer),
+	)
+	require.Nil(t, err)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "tracing wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"tracing wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"tracing account"}`, accountID))))
+	_, err = store.RetrieveAccount(walletID, uuid.New())
+	require.NotNil(t, err)
+
+	// Nested operations and Vault requests are children of the operation that made them.
+	storeAccount := tracer.find("store.store_account")
+	require.Len(t, storeAccount, 1)
+	assert.Equal(t, codes.Ok, storeAccount[0].status)
+	writes := 0
+	for _, span := range tracer.find("vault.write") {
+		if span.parent == storeAccount[0] {
+			writes++
+			assert.Equal(t, int64(0), span.attributes["vault.retries"].AsInt64())
+			assert.True(t, strings.HasSuffix(span.attributes["vault.path"].AsString(), "wallets/{id}/{id}"))
+		}
+	}
+	assert.Equal(t, 1, writes)
+	nested := 0
+	for _, span := range tracer.find("store.retrieve_wallet_by_id") {
+		if span.parent == storeAccount[0] {
+			nested++
+		}
+	}
+	assert.Equal(t, 1, nested)
+
+	// Failures record the type of error and the status returned by Vault.
+	var missing *recordedSpan
+	for _, span := range tracer.find("store.retrieve_account") {
+		if span.parent == nil {
+			missing = span
+		}
+	}
+	require.NotNil(t, missing)
+	assert.Equal(t, codes.Error, missing.status)
+	assert.Equal(t, vault.ErrorTypeNotFound, missing.attributes["error.type"].AsString())
+	found := false
+	for _, span := range tracer.find("vault.read") {
+		if span.parent == missing {
+			found = true
+			assert.Equal(t, int64(404), span.attributes["http.status_code"].AsInt64())
+		}
+	}
+	assert.True(t, found)
+
+	// No span identifies the wallet or account.
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	for _, span := range tracer.spans {
+		for key, value := range span.attributes {
+			assert.NotContains(t, value.Emit(), walletID.String(), "attribute %s of span %s", key, span.name)
+		}
+	}
+}
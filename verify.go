@@ -0,0 +1,200 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage
+
+import (
+	"bytes"
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// SecretStatus is the result of verifying a secret.
+type SecretStatus string
+
+const (
+	// SecretPlaintext is a secret held unencrypted.
+	SecretPlaintext SecretStatus = "plaintext"
+	// SecretDecryptable is an encrypted secret that the store can decrypt.
+	SecretDecryptable SecretStatus = "decryptable"
+	// SecretWrongKey is an encrypted secret that the store cannot decrypt, because its passphrase or ID differs from
+	// that used to write the secret, it has no passphrase, or the secret has been moved from elsewhere.
+	SecretWrongKey SecretStatus = "wrong key"
+	// SecretCorruptBase64 is a secret whose data is not valid base64.
+	SecretCorruptBase64 SecretStatus = "corrupt base64"
+	// SecretMalformedJSON is a secret whose data, once decrypted if required, is not valid JSON.
+	SecretMalformedJSON SecretStatus = "malformed JSON"
+	// SecretCorrupt is a secret whose envelope is unsupported or whose data does not match its checksum.
+	SecretCorrupt SecretStatus = "corrupt"
+	// SecretUnbound is an encrypted secret written before secrets were bound to their location, which the store
+	// decrypts only without WithRequireBinding.  UpgradeEnvelopes rewrites it bound to its location.
+	SecretUnbound SecretStatus = "unbound"
+)
+
+// SecretVerification is the result of verifying a single secret.
+type SecretVerification struct {
+	// Path is the path of the secret within the mount.
+	Path string
+	// Type is "wallet", "account", "index" or "pubkeys".
+	Type      string
+	WalletID  uuid.UUID
+	AccountID uuid.UUID
+	Status    SecretStatus
+}
+
+// VerificationReport is the result of verifying the secrets in a store.
+type VerificationReport struct {
+	// Expected is the status of secrets that the store can read: decryptable if it has a passphrase, otherwise plaintext.
+	Expected SecretStatus
+	// Secrets are the results for each secret, in the order verified.
+	Secrets []*SecretVerification
+	// Counts are the number of secrets with each status.
+	Counts map[SecretStatus]int
+}
+
+// Issues returns the secrets that the store cannot read, or that are held differently from those it writes.
+func (r *VerificationReport) Issues() []*SecretVerification {
+	issues := make([]*SecretVerification, 0)
+	for _, secret := range r.Secrets {
+		if secret.Status != r.Expected {
+			issues = append(issues, secret)
+		}
+	}
+	return issues
+}
+
+// Verify reads and classifies every wallet, account, accounts index and the public key index in the store, without
+// modifying anything.  Unlike retrieval, which skips secrets that cannot be read, it reports each of them, so mixed
+// encrypted and unencrypted data, or data written with a different passphrase, can be found.
+// Empty indices, which are never encrypted, are reported as if they were written by the store.
+func (s *Store) Verify(ctx context.Context) (_ *VerificationReport, err error) {
+	ctx, op := s.startOperation(ctx, "verify")
+	defer op.end(&err)
+	report := &VerificationReport{
+		Expected: s.expectedStatus(),
+		Secrets:  make([]*SecretVerification, 0),
+		Counts:   make(map[SecretStatus]int),
+	}
+
+	err = s.walkSecrets(ctx, func(ctx context.Context, path string, binding *secretBinding) error {
+		secret, err := s.kvGet(ctx, path)
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			// No index, or removed since listing.
+			return nil
+		}
+		if err != nil {
+			s.audit(ctx, AuditRead, binding, err)
+			return errors.Wrap(err, "failed to read "+binding.String())
+		}
+		status := s.verifySecret(ctx, secret, binding)
+		report.Secrets = append(report.Secrets, &SecretVerification{
+			Path:      path,
+			Type:      binding.kind,
+			WalletID:  binding.walletID,
+			AccountID: binding.accountID,
+			Status:    status,
+		})
+		report.Counts[status]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// verifySecret classifies a secret as read from Vault, auditing the read.
+func (s *Store) verifySecret(ctx context.Context, secret map[string]interface{}, binding *secretBinding) SecretStatus {
+	var err error
+	defer func() {
+		s.audit(ctx, AuditRead, binding, err)
+	}()
+
+	encoded, _ := secret["data"].(string)
+	if _, err = b64.URLEncoding.DecodeString(encoded); err != nil {
+		err = &decodeError{err: err}
+		return SecretCorruptBase64
+	}
+	env, err := parseEnvelope(secret)
+	if err != nil {
+		err = &decodeError{err: err}
+		return SecretCorrupt
+	}
+	if env.version != 0 && env.checksum != checksum(env.data) {
+		err = &decodeError{err: errors.New("secret checksum mismatch")}
+		return SecretCorrupt
+	}
+
+	if unencrypted(env.data, binding) {
+		return s.expectedStatus()
+	}
+
+	var encrypted bool
+	if env.version == 0 {
+		// JSON never starts with the magic of bound data or the version byte of ecodec.
+		encrypted = bytes.HasPrefix(env.data, boundMagic) || env.data[0] == 0x01
+	} else {
+		encrypted = env.scheme != schemeNone
+	}
+	if !encrypted {
+		if !json.Valid(env.data) {
+			return SecretMalformedJSON
+		}
+		return SecretPlaintext
+	}
+
+	if !s.hasPassphrase() {
+		return SecretWrongKey
+	}
+	if env.version != 0 && env.scheme != schemeAESGCM {
+		return SecretCorrupt
+	}
+	unbound := env.version == 0 && !bytes.HasPrefix(env.data, boundMagic)
+	data, err := s.openEnvelope(env, binding)
+	if err != nil {
+		if errors.Is(err, errUnbound) {
+			err = nil
+			return SecretUnbound
+		}
+		err = &decodeError{err: err}
+		return SecretWrongKey
+	}
+	defer zero(data)
+	if !json.Valid(data) {
+		return SecretMalformedJSON
+	}
+	if unbound {
+		return SecretUnbound
+	}
+	return SecretDecryptable
+}
+
+// expectedStatus returns the status of secrets written by the store.
+func (s *Store) expectedStatus() SecretStatus {
+	if s.hasPassphrase() {
+		return SecretDecryptable
+	}
+	return SecretPlaintext
+}
+
+// hasPassphrase returns true if the store has a passphrase with which to encrypt and decrypt secrets.
+func (s *Store) hasPassphrase() bool {
+	s.keyMu.RLock()
+	defer s.keyMu.RUnlock()
+	return len(s.passphrase) > 0
+}
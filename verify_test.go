@@ -0,0 +1,144 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultstorage_test
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	vaultapi "github.com/hashicorp/vault/api"
+	vault "github.com/stake-capital/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wealdtech/go-ecodec"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	mountKVv1(t, mount)
+	newStore := func(opts ...vault.Option) *vault.Store {
+		store, err := vault.New(append([]vault.Option{
+			vault.WithVaultAddr("http://localhost:8200"),
+			vault.WithVaultSecretMountPath(mount),
+			vault.WithVaultToken("golang-test"),
+			vault.WithVaultAuth("token"),
+		}, opts...)...)
+		require.Nil(t, err)
+		return store.(*vault.Store)
+	}
+	store := newStore(vault.WithPassphrase([]byte("test")))
+
+	config := vaultapi.DefaultConfig()
+	config.Address = "http://localhost:8200"
+	client, err := vaultapi.NewClient(config)
+	require.Nil(t, err)
+	client.SetToken("golang-test")
+	kv := client.KVv1(mount)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "verify wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"verify wallet"}`, walletID))))
+	require.Nil(t, store.StoreAccountsIndex(walletID, []byte("{}")))
+	goodID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, goodID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"good account"}`, goodID))))
+	malformedID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, malformedID, []byte("this is not JSON account data")))
+
+	plaintextID := uuid.New()
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, plaintextID), map[string]interface{}{
+		"data": b64.URLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"uuid":%q,"name":"plaintext account"}`, plaintextID))),
+	}))
+	wrongKeyID := uuid.New()
+	encrypted, err := ecodec.Encrypt([]byte(fmt.Sprintf(`{"uuid":%q,"name":"wrong key account"}`, wrongKeyID)), []byte("other"))
+	require.Nil(t, err)
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, wrongKeyID), map[string]interface{}{
+		"data": b64.URLEncoding.EncodeToString(encrypted),
+	}))
+	unboundID := uuid.New()
+	encrypted, err = ecodec.Encrypt([]byte(fmt.Sprintf(`{"uuid":%q,"name":"unbound account"}`, unboundID)), []byte("test"))
+	require.Nil(t, err)
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, unboundID), map[string]interface{}{
+		"data": b64.URLEncoding.EncodeToString(encrypted),
+	}))
+	badBase64ID := uuid.New()
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, badBase64ID), map[string]interface{}{
+		"data": "not base64!",
+	}))
+	corruptID := uuid.New()
+	secret, err := kv.Get(ctx, fmt.Sprintf("wallets/%s/%s", walletID, goodID))
+	require.Nil(t, err)
+	secret.Data["checksum"] = "sha256:00"
+	require.Nil(t, kv.Put(ctx, fmt.Sprintf("wallets/%s/%s", walletID, corruptID), secret.Data))
+
+	report, err := store.Verify(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, vault.SecretDecryptable, report.Expected)
+	assert.Equal(t, map[vault.SecretStatus]int{
+		vault.SecretDecryptable:   3,
+		vault.SecretPlaintext:     1,
+		vault.SecretWrongKey:      1,
+		vault.SecretCorruptBase64: 1,
+		vault.SecretMalformedJSON: 1,
+		vault.SecretCorrupt:       1,
+		vault.SecretUnbound:       1,
+	}, report.Counts)
+	issues := make(map[uuid.UUID]vault.SecretStatus)
+	for _, issue := range report.Issues() {
+		assert.Equal(t, "account", issue.Type)
+		assert.Equal(t, walletID, issue.WalletID)
+		assert.Equal(t, fmt.Sprintf("wallets/%s/%s", walletID, issue.AccountID), issue.Path)
+		issues[issue.AccountID] = issue.Status
+	}
+	assert.Equal(t, map[uuid.UUID]vault.SecretStatus{
+		plaintextID: vault.SecretPlaintext,
+		wrongKeyID:  vault.SecretWrongKey,
+		badBase64ID: vault.SecretCorruptBase64,
+		malformedID: vault.SecretMalformedJSON,
+		corruptID:   vault.SecretCorrupt,
+		unboundID:   vault.SecretUnbound,
+	}, issues)
+
+	// Unbound secrets are reported whether or not the store requires binding, although a store that requires binding
+	// does not decrypt them so cannot tell if they are encrypted with its passphrase.  A warning is logged when one is
+	// read.
+	report, err = newStore(vault.WithPassphrase([]byte("test")), vault.WithRequireBinding()).Verify(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 2, report.Counts[vault.SecretUnbound])
+	logger := &recordingLogger{}
+	_, err = newStore(vault.WithPassphrase([]byte("test")), vault.WithLogger(logger)).RetrieveAccount(walletID, unboundID)
+	require.Nil(t, err)
+	require.Len(t, logger.find("read secret that is not bound to its location; run UpgradeEnvelopes to bind it"), 1)
+
+	// Without a passphrase the encrypted wallet and accounts are reported as using the wrong key.
+	report, err = newStore().Verify(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, vault.SecretPlaintext, report.Expected)
+	assert.Equal(t, 5, report.Counts[vault.SecretWrongKey])
+
+	// Unreadable accounts are skipped unless retrieval is strict.
+	page, err := store.ListAccounts(ctx, walletID, "", 10)
+	require.Nil(t, err)
+	assert.Len(t, page.Items, 3)
+	_, err = newStore(vault.WithPassphrase([]byte("test")), vault.WithStrictRetrieval()).ListAccounts(ctx, walletID, "", 10)
+	assert.NotNil(t, err)
+
+	// Retrieval of all accounts stops at the first that cannot be read, logging the error, rather than exiting.
+	logger = &recordingLogger{}
+	for range newStore(vault.WithPassphrase([]byte("test")), vault.WithStrictRetrieval(), vault.WithLogger(logger)).RetrieveAccounts(walletID) {
+	}
+	require.Len(t, logger.find("failed to retrieve accounts"), 1)
+}
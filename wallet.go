@@ -16,9 +16,8 @@ package vaultstorage
 import (
 	"context"
 	"encoding/json"
-	"log"
-
-	b64 "encoding/base64"
+	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -35,27 +34,37 @@ type WalletSecret struct {
 // StoreWallet stores wallet-level data.  It will fail if it cannot store the data.
 // Note that this will overwrite any existing data; it is up to higher-level functions to check for the presence of a wallet with
 // the wallet name and handle clashes accordingly.
-func (s *Store) StoreWallet(id uuid.UUID, name string, data []byte) error {
+func (s *Store) StoreWallet(id uuid.UUID, name string, data []byte) (err error) {
+	ctx, op := s.startOperation(context.Background(), "store_wallet")
+	defer op.end(&err)
+	if err := s.checkWritable("store wallet"); err != nil {
+		return err
+	}
+
 	path := s.walletHeaderPath(id)
-	var err error
-	data, err = s.encryptIfRequired(data)
+	secret, err := s.sealSecret(data, walletBinding(id))
 	if err != nil {
 		return errors.Wrap(err, "failed to encrypt wallet")
 	}
 
-	sEnc := b64.URLEncoding.EncodeToString(data)
-	_, err = s.client.KVv2(s.vault_secrets_mount_path).Put(context.Background(), path, map[string]interface{}{
-		"data": sEnc,
-	})
+	err = s.kvPut(ctx, path, secret)
+	s.audit(ctx, AuditWrite, walletBinding(id), err)
 	if err != nil {
 		return errors.Wrap(err, "failed to store wallet")
 	}
-	return nil
+
+	return s.storeCustomMetadata(ctx, path, &Metadata{
+		Type:       "wallet",
+		WalletID:   id,
+		WalletName: name,
+	})
 }
 
 // RetrieveWallet retrieves wallet-level data.  It will fail if it cannot retrieve the data.
-func (s *Store) RetrieveWallet(walletName string) ([]byte, error) {
-	for data := range s.RetrieveWallets() {
+func (s *Store) RetrieveWallet(walletName string) (_ []byte, err error) {
+	ctx, op := s.startOperation(context.Background(), "retrieve_wallet")
+	defer op.end(&err)
+	for data := range s.retrieveWallets(ctx) {
 		info := &struct {
 			Name string `json:"name"`
 		}{}
@@ -64,12 +73,18 @@ func (s *Store) RetrieveWallet(walletName string) ([]byte, error) {
 			return data, nil
 		}
 	}
-	return nil, errors.New("wallet not found")
+	return nil, errWalletNotFound
 }
 
 // RetrieveWalletByID retrieves wallet-level data.  It will fail if it cannot retrieve the data.
 func (s *Store) RetrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
-	for data := range s.RetrieveWallets() {
+	return s.retrieveWalletByID(context.Background(), walletID)
+}
+
+func (s *Store) retrieveWalletByID(ctx context.Context, walletID uuid.UUID) (_ []byte, err error) {
+	ctx, op := s.startOperation(ctx, "retrieve_wallet_by_id")
+	defer op.end(&err)
+	for data := range s.retrieveWallets(ctx) {
 		info := &struct {
 			ID uuid.UUID `json:"uuid"`
 		}{}
@@ -78,49 +93,100 @@ func (s *Store) RetrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
 			return data, nil
 		}
 	}
-	return nil, errors.New("wallet not found")
+	return nil, errWalletNotFound
 }
 
 // RetrieveWallets retrieves wallet-level data for all wallets.
 func (s *Store) RetrieveWallets() <-chan []byte {
+	return s.retrieveWallets(context.Background())
+}
+
+func (s *Store) retrieveWallets(ctx context.Context) <-chan []byte {
+	ctx, op := s.startOperation(ctx, "retrieve_wallets")
 	ch := make(chan []byte, 1024)
 	go func() {
-		endpoint := "/" + s.vault_secrets_mount_path + "/metadata/wallets"
-		walletList, err := s.client.Logical().List(endpoint)
+		defer close(ch)
+		var err error
+		defer op.end(&err)
+		if err = s.sendWallets(ctx, ch); err != nil {
+			s.logger.Error("failed to retrieve wallets", "error", err)
+		}
+	}()
+	return ch
+}
+
+// sendWallets sends the data of each wallet to the channel.  Wallets that cannot be retrieved or decrypted are
+// skipped unless retrieval is strict, in which case the first is returned as an error.
+func (s *Store) sendWallets(ctx context.Context, ch chan<- []byte) error {
+	walletList, err := s.kvList(ctx, "wallets")
+	if err != nil {
+		return errors.Wrap(err, "failed to list wallets")
+	}
+
+	for _, walletIdWithSuffix := range walletList {
+		if !strings.HasSuffix(walletIdWithSuffix, "/") {
+			// Not a wallet directory
+			continue
+		}
+		walletId := strings.TrimSuffix(walletIdWithSuffix, "/")
+
+		uuidId, _ := uuid.Parse(walletId)
+		path := s.walletHeaderPath(uuidId)
+		binding := walletBinding(uuidId)
 
+		secret, err := s.kvGet(ctx, path)
 		if err != nil {
-			log.Fatalln(err)
+			s.audit(ctx, AuditRead, binding, err)
+			if s.strict_retrieval {
+				return errors.Wrap(err, fmt.Sprintf("failed to retrieve %s", binding))
+			}
+			s.logger.Warn("skipped wallet that could not be retrieved", secretFields(binding, err)...)
+			continue
 		}
 
-		if err == nil && walletList != nil && walletList.Data != nil {
-			k := walletList.Data["keys"].([]interface{})
-			if k != nil {
-				for _, item := range k {
-					walletIdWithSuffix := item.(string)
-					lastCharPos := len(walletIdWithSuffix) - 1
-					walletId := walletIdWithSuffix[:lastCharPos]
-
-					uuidId, _ := uuid.Parse(walletId)
-					path := s.walletHeaderPath(uuidId)
-
-					secret, err := s.client.KVv2(s.vault_secrets_mount_path).Get(context.Background(), path)
-					if err != nil {
-						continue
-					}
-
-					returnedData, _ := secret.Data["data"].(string)
-
-					sDec, _ := b64.URLEncoding.DecodeString(returnedData)
-					data, err := s.decryptIfRequired([]byte(sDec))
-					if err != nil {
-						continue
-					}
-					ch <- data
-				}
+		data, err := s.openSecret(secret, binding)
+		s.audit(ctx, AuditRead, binding, err)
+		if err != nil {
+			if s.strict_retrieval {
+				return errors.Wrap(err, fmt.Sprintf("failed to decrypt %s", binding))
 			}
+			s.logger.Warn("skipped wallet that could not be decrypted", secretFields(binding, err)...)
+			continue
 		}
+		ch <- data
+	}
+	return nil
+}
 
-		close(ch)
-	}()
-	return ch
+// DeleteWallet permanently deletes a wallet and its accounts index.  It will fail if the wallet still has accounts.
+func (s *Store) DeleteWallet(walletID uuid.UUID) (err error) {
+	ctx, op := s.startOperation(context.Background(), "delete_wallet")
+	defer op.end(&err)
+	if err := s.checkWritable("delete wallet"); err != nil {
+		return err
+	}
+
+	if _, err := s.retrieveWalletByID(ctx, walletID); err != nil {
+		return err
+	}
+
+	keys, err := s.kvList(ctx, "wallets/"+s.walletPath(walletID))
+	if err != nil {
+		return errors.Wrap(err, "failed to list wallet contents")
+	}
+	for _, key := range keys {
+		if key != walletID.String() && key != "index" {
+			return errors.New("wallet has accounts")
+		}
+	}
+
+	if err := s.kvDelete(ctx, s.walletIndexPath(walletID)); err != nil {
+		return errors.Wrap(err, "failed to delete wallet index")
+	}
+	err = s.kvDelete(ctx, s.walletHeaderPath(walletID))
+	s.audit(ctx, AuditDelete, walletBinding(walletID), err)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete wallet")
+	}
+	return nil
 }
@@ -74,3 +74,46 @@ func TestStoreRetrieveWalletByName(t *testing.T) {
 	for range store.RetrieveWallets() {
 	}
 }
+
+func TestDeleteWallet(t *testing.T) {
+	store, err := vault.New(
+		vault.WithPassphrase([]byte("test")),
+		vault.WithVaultAddr("http://localhost:8200"),
+		vault.WithVaultSecretMountPath("secret"),
+		vault.WithVaultToken("golang-test"),
+		vault.WithVaultAuth("token"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultStore := store.(*vault.Store)
+
+	walletID := uuid.New()
+	walletName := fmt.Sprintf("test wallet %s", walletID)
+	walletData := []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, walletID, walletName))
+	accountID := uuid.New()
+	accountData := []byte(fmt.Sprintf(`{"uuid":%q,"name":"test account"}`, accountID))
+
+	err = store.StoreWallet(walletID, walletName, walletData)
+	require.Nil(t, err)
+	err = store.StoreAccount(walletID, accountID, accountData)
+	require.Nil(t, err)
+
+	// Cannot delete a wallet with accounts.
+	err = vaultStore.DeleteWallet(walletID)
+	assert.EqualError(t, err, "wallet has accounts")
+
+	err = vaultStore.DeleteAccount(walletID, accountID)
+	require.Nil(t, err)
+	_, err = store.RetrieveAccount(walletID, accountID)
+	assert.NotNil(t, err)
+	err = vaultStore.DeleteAccount(walletID, accountID)
+	assert.EqualError(t, err, "account not found")
+
+	err = vaultStore.DeleteWallet(walletID)
+	require.Nil(t, err)
+	_, err = store.RetrieveWalletByID(walletID)
+	assert.NotNil(t, err)
+	err = vaultStore.DeleteWallet(walletID)
+	assert.EqualError(t, err, "wallet not found")
+}